@@ -40,8 +40,10 @@ func printRequest(req *request.Request) {
 	fmt.Printf("- Target: %s\n", req.RequestLine.RequestTarget)
 	fmt.Printf("- Version: %s\n", req.RequestLine.HttpVersion)
 	fmt.Println("Headers:")
-	for k, v := range req.Headers {
-		fmt.Printf("- %s: %s\n", k, v)
+	for k, values := range req.Headers.All() {
+		for _, v := range values {
+			fmt.Printf("- %s: %s\n", k, v)
+		}
 	}
 	fmt.Println("Body:")
 	fmt.Println(string(req.Body))
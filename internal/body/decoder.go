@@ -0,0 +1,248 @@
+package body
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/davidw1457/httpfromtcp/internal/headers"
+)
+
+const maxChunkSizeLineLength = 4096
+
+// ErrMalformedChunk is returned for chunked bodies that break RFC 9112
+// section 7.1. It is wrapped with context, so match it with errors.Is.
+var ErrMalformedChunk = errors.New("malformed chunk")
+
+type decoderState int
+
+const (
+	decoderStateLength decoderState = iota
+	decoderStateUntilClose
+	decoderStateChunkSize
+	decoderStateChunkData
+	decoderStateChunkDataEnd
+	decoderStateTrailers
+	decoderStateDone
+)
+
+// Decoder removes the framing from a message body as its bytes arrive. The
+// decoded bytes are kept until they are taken with Read or Bytes.
+//
+// CheckSize, if set, is called with the body size announced so far by a
+// chunked body, before the chunk is read, so that an oversized body is
+// rejected early. CheckTrailers, if set, is called after every step of
+// parsing the trailer section with the input, the bytes consumed and
+// whether the section ended, the same values headers.ParseFolded returns.
+type Decoder struct {
+	Trailers      headers.Headers
+	ObsFold       headers.ObsFoldPolicy
+	CheckSize     func(size int) error
+	CheckTrailers func(data []byte, n int, done bool) error
+
+	state     decoderState
+	remaining int
+	size      int
+	decoded   []byte
+}
+
+// NewLengthDecoder decodes a body of exactly length bytes.
+func NewLengthDecoder(length int) *Decoder {
+	d := &Decoder{state: decoderStateLength, remaining: length}
+	if length == 0 {
+		d.state = decoderStateDone
+	}
+	return d
+}
+
+// NewChunkedDecoder decodes a chunked body, adding its trailer fields to
+// trailers.
+func NewChunkedDecoder(trailers headers.Headers) *Decoder {
+	return &Decoder{state: decoderStateChunkSize, Trailers: trailers}
+}
+
+// NewCloseDecoder decodes a body that ends when the connection does.
+func NewCloseDecoder() *Decoder {
+	return &Decoder{state: decoderStateUntilClose}
+}
+
+// Decode consumes as much of data as it can and returns the number of
+// bytes consumed. Bytes belonging to an incomplete chunk size line or
+// trailer field are left for the next call.
+func (d *Decoder) Decode(data []byte) (int, error) {
+	bytesParsed := 0
+	for d.state != decoderStateDone {
+		state := d.state
+		n, err := d.decodeSingle(data[bytesParsed:])
+		if err != nil {
+			return bytesParsed, fmt.Errorf("Decoder.Decode: %w", err)
+		}
+		bytesParsed += n
+		if n == 0 && d.state == state {
+			break
+		}
+	}
+	return bytesParsed, nil
+}
+
+func (d *Decoder) decodeSingle(data []byte) (int, error) {
+	switch d.state {
+	case decoderStateLength:
+		n := min(len(data), d.remaining)
+		d.appendBody(data[:n])
+		d.remaining -= n
+		if d.remaining == 0 {
+			d.state = decoderStateDone
+		}
+		return n, nil
+	case decoderStateUntilClose:
+		d.appendBody(data)
+		return len(data), nil
+	case decoderStateChunkSize:
+		idx := bytes.Index(data, []byte("\r\n"))
+		if idx == -1 {
+			if len(data) > maxChunkSizeLineLength {
+				return 0, fmt.Errorf(
+					"decodeSingle: %w: chunk size line too long",
+					ErrMalformedChunk,
+				)
+			}
+			return 0, nil
+		}
+
+		chunkSize, err := parseChunkSize(string(data[:idx]))
+		if err != nil {
+			return 0, fmt.Errorf("decodeSingle: %w", err)
+		}
+
+		if d.CheckSize != nil {
+			err = d.CheckSize(d.size + chunkSize)
+			if err != nil {
+				return 0, fmt.Errorf("decodeSingle: %w", err)
+			}
+		}
+
+		if chunkSize == 0 {
+			d.state = decoderStateTrailers
+		} else {
+			d.remaining = chunkSize
+			d.state = decoderStateChunkData
+		}
+		return idx + 2, nil
+	case decoderStateChunkData:
+		n := min(len(data), d.remaining)
+		d.appendBody(data[:n])
+		d.remaining -= n
+		if d.remaining == 0 {
+			d.state = decoderStateChunkDataEnd
+		}
+		return n, nil
+	case decoderStateChunkDataEnd:
+		if len(data) < 2 {
+			return 0, nil
+		}
+		if !bytes.HasPrefix(data, []byte("\r\n")) {
+			return 0, fmt.Errorf(
+				"decodeSingle: %w: chunk data missing CRLF",
+				ErrMalformedChunk,
+			)
+		}
+		d.state = decoderStateChunkSize
+		return 2, nil
+	case decoderStateTrailers:
+		n, done, err := d.Trailers.ParseFolded(data, d.ObsFold)
+		if err != nil {
+			return 0, fmt.Errorf("decodeSingle: %w", err)
+		}
+
+		if d.CheckTrailers != nil {
+			err = d.CheckTrailers(data, n, done)
+			if err != nil {
+				return 0, fmt.Errorf("decodeSingle: %w", err)
+			}
+		}
+
+		if done {
+			d.state = decoderStateDone
+		}
+		return n, nil
+	default:
+		return 0, fmt.Errorf("invalid state")
+	}
+}
+
+// EOF tells the decoder that the connection has ended. That completes a
+// body delimited by the connection closing; any other unfinished body
+// gets io.ErrUnexpectedEOF.
+func (d *Decoder) EOF() error {
+	switch d.state {
+	case decoderStateDone:
+		return nil
+	case decoderStateUntilClose:
+		d.state = decoderStateDone
+		return nil
+	default:
+		return io.ErrUnexpectedEOF
+	}
+}
+
+// Done reports whether the whole body, trailers included, was decoded.
+func (d *Decoder) Done() bool {
+	return d.state == decoderStateDone
+}
+
+// Size returns the number of body bytes decoded so far.
+func (d *Decoder) Size() int {
+	return d.size
+}
+
+// Buffered returns the number of decoded bytes not taken yet.
+func (d *Decoder) Buffered() int {
+	return len(d.decoded)
+}
+
+// Read takes up to len(p) decoded bytes.
+func (d *Decoder) Read(p []byte) int {
+	n := copy(p, d.decoded)
+	d.decoded = d.decoded[n:]
+	return n
+}
+
+// Bytes takes every decoded byte.
+func (d *Decoder) Bytes() []byte {
+	decoded := d.decoded
+	d.decoded = nil
+	return decoded
+}
+
+func (d *Decoder) appendBody(data []byte) {
+	d.decoded = append(d.decoded, data...)
+	d.size += len(data)
+}
+
+func parseChunkSize(line string) (int, error) {
+	sizeText, _, _ := strings.Cut(line, ";")
+	sizeText = strings.TrimRight(sizeText, " \t")
+
+	validSize, err := regexp.MatchString("^[0-9A-Fa-f]{1,15}$", sizeText)
+	if err != nil {
+		return 0, fmt.Errorf("parseChunkSize: %w", err)
+	} else if !validSize {
+		return 0, fmt.Errorf(
+			"parseChunkSize: %w: invalid chunk size: %s",
+			ErrMalformedChunk,
+			line,
+		)
+	}
+
+	size, err := strconv.ParseInt(sizeText, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parseChunkSize: %w", err)
+	}
+
+	return int(size), nil
+}
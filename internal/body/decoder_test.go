@@ -0,0 +1,110 @@
+package body
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/davidw1457/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLengthDecoder(t *testing.T) {
+	// Test: Bytes past the length are left for the next message
+	d := NewLengthDecoder(5)
+	n, err := d.Decode([]byte("helloGET"))
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+	assert.True(t, d.Done())
+	assert.Equal(t, "hello", string(d.Bytes()))
+
+	// Test: Zero length is done right away
+	assert.True(t, NewLengthDecoder(0).Done())
+
+	// Test: Connection ending early
+	d = NewLengthDecoder(5)
+	_, err = d.Decode([]byte("hel"))
+	require.NoError(t, err)
+	assert.ErrorIs(t, d.EOF(), io.ErrUnexpectedEOF)
+}
+
+func TestChunkedDecoder(t *testing.T) {
+	trailers := headers.NewHeaders()
+	d := NewChunkedDecoder(trailers)
+
+	// Test: Chunks split across calls, with extensions and trailers
+	data := "5;name=value\r\nhello\r\n6\r\n world\r\n0\r\nExpires: never\r\n\r\nnext"
+	consumed := 0
+	for _, end := range []int{3, 17, 30, len(data)} {
+		n, err := d.Decode([]byte(data[consumed:end]))
+		require.NoError(t, err)
+		consumed += n
+	}
+	assert.True(t, d.Done())
+	assert.Equal(t, "next", data[consumed:])
+	assert.Equal(t, "hello world", string(d.Bytes()))
+	assert.Equal(t, 11, d.Size())
+	assert.Equal(t, []string{"never"}, trailers.Values("Expires"))
+
+	// Test: Size check sees the announced size before the chunk data
+	tooLarge := errors.New("too large")
+	d = NewChunkedDecoder(headers.NewHeaders())
+	d.CheckSize = func(size int) error {
+		if size > 8 {
+			return tooLarge
+		}
+		return nil
+	}
+	_, err := d.Decode([]byte("5\r\nhello\r\n5\r\n"))
+	assert.ErrorIs(t, err, tooLarge)
+
+	// Test: Malformed chunks
+	for _, data := range []string{
+		"zz\r\n",
+		"5\r\nhelloXX",
+		"1234567890abcdef0\r\n",
+	} {
+		_, err = NewChunkedDecoder(headers.NewHeaders()).Decode([]byte(data))
+		assert.ErrorIs(t, err, ErrMalformedChunk, data)
+	}
+}
+
+func TestReader(t *testing.T) {
+	// Test: Body delimited by the connection closing is read to EOF
+	src := &testSource{chunks: []string{"hel", "lo"}}
+	src.decoder = NewCloseDecoder()
+	body, err := io.ReadAll(NewReader(src, src.decoder))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+
+	// Test: Truncated chunked body is an error, and stays one
+	src = &testSource{chunks: []string{"5\r\nhe"}}
+	src.decoder = NewChunkedDecoder(headers.NewHeaders())
+	r := NewReader(src, src.decoder)
+	_, err = io.ReadAll(r)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.ErrorIs(t, r.Close(), io.ErrUnexpectedEOF)
+}
+
+// testSource hands chunks to its decoder one ReadMore at a time.
+type testSource struct {
+	decoder *Decoder
+	chunks  []string
+	buf     []byte
+}
+
+func (ts *testSource) Parse() error {
+	n, err := ts.decoder.Decode(ts.buf)
+	ts.buf = ts.buf[n:]
+	return err
+}
+
+func (ts *testSource) ReadMore() error {
+	if len(ts.chunks) == 0 {
+		return io.EOF
+	}
+	ts.buf = append(ts.buf, ts.chunks[0]...)
+	ts.chunks = ts.chunks[1:]
+	return nil
+}
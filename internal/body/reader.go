@@ -0,0 +1,81 @@
+package body
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Source is the connection a streamed body is read from. Parse feeds the
+// bytes buffered so far to the message, and so to its Decoder; ReadMore
+// reads from the connection into that buffer.
+type Source interface {
+	Parse() error
+	ReadMore() error
+}
+
+// Reader streams the body decoded by a Decoder, reading from its Source
+// whenever the decoder runs dry.
+type Reader struct {
+	source  Source
+	decoder *Decoder
+	closed  bool
+	err     error
+}
+
+func NewReader(source Source, decoder *Decoder) *Reader {
+	return &Reader{source: source, decoder: decoder}
+}
+
+func (br *Reader) Read(p []byte) (int, error) {
+	if br.closed {
+		return 0, fmt.Errorf("body.Read: body already closed")
+	}
+	if br.err != nil {
+		return 0, br.err
+	}
+
+	d := br.decoder
+	for d.Buffered() == 0 && !d.Done() {
+		err := br.source.Parse()
+		if err != nil {
+			br.err = fmt.Errorf("body.Read: %w", err)
+			return 0, br.err
+		}
+		if d.Buffered() > 0 || d.Done() {
+			break
+		}
+
+		err = br.source.ReadMore()
+		if errors.Is(err, io.EOF) {
+			err = d.EOF()
+		}
+		if err != nil {
+			br.err = fmt.Errorf("body.Read: %w", err)
+			return 0, br.err
+		}
+	}
+
+	if d.Buffered() == 0 {
+		return 0, io.EOF
+	}
+
+	return d.Read(p), nil
+}
+
+// Close discards whatever is left of the body so that the next message on
+// the connection can be read. It returns the error that ended the body
+// early, if any, even when the caller already saw it.
+func (br *Reader) Close() error {
+	if br.closed {
+		return nil
+	}
+
+	_, err := io.Copy(io.Discard, br)
+	br.closed = true
+	if err != nil {
+		return fmt.Errorf("body.Close: %w", err)
+	}
+
+	return nil
+}
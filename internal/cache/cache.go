@@ -0,0 +1,429 @@
+package cache
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/davidw1457/httpfromtcp/internal/client"
+	"github.com/davidw1457/httpfromtcp/internal/headers"
+	"github.com/davidw1457/httpfromtcp/internal/response"
+)
+
+const (
+	cacheStatusHeader    = "X-Cache"
+	defaultMaxEntryBytes = 10 << 20
+)
+
+// cacheableStatus lists the status codes that are heuristically cacheable
+// by default (RFC 9110 section 15.1). Only these are stored.
+var cacheableStatus = []response.StatusCode{
+	response.OK,
+	response.NONAUTHORITATIVEINFO,
+	response.NOCONTENT,
+	response.PARTIALCONTENT,
+	response.MULTIPLECHOICES,
+	response.MOVEDPERMANENTLY,
+	response.PERMANENTREDIRECT,
+	response.NOTFOUND,
+	response.METHODNOTALLOWED,
+	response.GONE,
+	response.URITOOLONG,
+	response.NOTIMPLEMENTED,
+}
+
+// Store holds cache entries by key.
+type Store interface {
+	Get(key string) (*Entry, bool)
+	Set(key string, entry *Entry) error
+	Delete(key string)
+}
+
+// Transport is a client.RoundTripper that caches GET responses the way a
+// shared cache does (RFC 9111). It honors Cache-Control max-age, s-maxage,
+// no-cache, no-store and private, Expires and Vary, and revalidates stale
+// entries with If-None-Match or If-Modified-Since.
+//
+// Entries are kept in Memory and, when Disk is set, also written to disk
+// and read back from there after being evicted from memory. Either store
+// may be nil. One entry is kept per URL; a request whose Vary fields
+// differ replaces it.
+//
+// A request carrying its own If-None-Match or If-Modified-Since gets a 304
+// when the entry satisfies it. When such a request revalidates an entry,
+// the client's validators are sent along with the entry's.
+//
+// Responses carry X-Cache: HIT when served from the cache, including after
+// a successful revalidation, and X-Cache: MISS otherwise. Hits also carry
+// an Age field.
+type Transport struct {
+	// Next defaults to client.DefaultTransport.
+	Next   client.RoundTripper
+	Memory Store
+	Disk   Store
+	// MaxEntryBytes caps the body of a response that is stored; larger
+	// ones are passed on without being kept. It defaults to 10 MiB.
+	MaxEntryBytes int
+	// Logger defaults to the standard logger.
+	Logger *log.Logger
+
+	now func() time.Time
+}
+
+// New returns a cache in front of next holding up to maxBytes in memory.
+func New(next client.RoundTripper, maxBytes int) *Transport {
+	return &Transport{
+		Next:          next,
+		Memory:        NewMemoryStore(maxBytes),
+		MaxEntryBytes: maxBytes,
+	}
+}
+
+func (t *Transport) RoundTrip(req *client.Request) (*response.Response, error) {
+	key := req.URL.String()
+
+	if req.Method != "GET" {
+		if req.Method != "HEAD" && req.Method != "OPTIONS" && req.Method != "TRACE" {
+			t.delete(key)
+		}
+		return t.next().RoundTrip(req)
+	}
+
+	reqCC := cacheControl(req.Headers)
+	if reqCC.has("no-store") {
+		return t.fetch(req, key, false)
+	}
+
+	entry, ok := t.get(key)
+	if !ok || !entry.varyMatches(req.Headers) {
+		return t.fetch(req, key, true)
+	}
+
+	now := t.clock()
+	revalidate := reqCC.has("no-cache")
+	if maxAge, ok := reqCC.seconds("max-age"); ok && entry.age(now) > maxAge {
+		revalidate = true
+	}
+	if !revalidate && entry.fresh(now) {
+		return t.hit(req, entry, now), nil
+	}
+
+	if !entry.hasValidators() {
+		return t.fetch(req, key, true)
+	}
+	return t.revalidate(req, key, entry)
+}
+
+// revalidate asks the origin whether entry is still current, sending the
+// entry's validators along with any the client sent itself. A 304 that
+// selects the entry updates and serves it; a 304 that only answers the
+// client's own validators is passed on, and any other response replaces
+// the entry.
+func (t *Transport) revalidate(
+	req *client.Request,
+	key string,
+	entry *Entry,
+) (*response.Response, error) {
+	conditional := *req
+	conditional.Headers = req.Headers.Clone()
+	etag, hasETag := entry.Headers.Get("ETag")
+	if hasETag && !req.Headers.ContainsToken("If-None-Match", "*") {
+		conditional.Headers.Add("If-None-Match", etag)
+	}
+	if lastModified, ok := entry.Headers.Get("Last-Modified"); ok {
+		// The earlier date is the stricter condition, so a 304 answers
+		// both the client's date and the entry's.
+		since, ok, err := req.Headers.Time("If-Modified-Since")
+		modified, _, lmErr := entry.Headers.Time("Last-Modified")
+		if !ok || err != nil || (lmErr == nil && modified.Before(since)) {
+			conditional.Headers.Set("If-Modified-Since", lastModified)
+		}
+	}
+
+	requestTime := t.clock()
+	resp, err := t.next().RoundTrip(&conditional)
+	if err != nil {
+		return nil, fmt.Errorf("cache.revalidate: %w", err)
+	}
+
+	if resp.StatusLine.StatusCode != response.NOTMODIFIED {
+		return t.store(req, key, resp, requestTime), nil
+	}
+
+	newETag, ok := resp.Headers.Get("ETag")
+	if ok && (!hasETag || !sameETag(newETag, etag)) {
+		resp.Headers.Set(cacheStatusHeader, "MISS")
+		return resp, nil
+	}
+	_ = resp.BodyReader.Close()
+
+	updated := *entry
+	fields := resp.Headers.Clone()
+	fields.Delete("Content-Length")
+	fields.Delete("Transfer-Encoding")
+	updated.Headers = entry.Headers.Clone()
+	updated.Headers.Merge(fields)
+	updated.RequestTime = requestTime
+	updated.ResponseTime = t.clock()
+	t.set(key, &updated)
+
+	return t.hit(req, &updated, updated.ResponseTime), nil
+}
+
+// fetch forwards req to the origin, storing the response if allowed.
+func (t *Transport) fetch(
+	req *client.Request,
+	key string,
+	storable bool,
+) (*response.Response, error) {
+	requestTime := t.clock()
+	resp, err := t.next().RoundTrip(req)
+	if err != nil {
+		return nil, fmt.Errorf("cache.fetch: %w", err)
+	}
+
+	if !storable {
+		resp.Headers.Set(cacheStatusHeader, "MISS")
+		return resp, nil
+	}
+	return t.store(req, key, resp, requestTime), nil
+}
+
+// store marks resp as a miss and, if it may be stored, arranges for it to
+// be saved once its body has been read to the end.
+func (t *Transport) store(
+	req *client.Request,
+	key string,
+	resp *response.Response,
+	requestTime time.Time,
+) *response.Response {
+	if !canStore(req, resp) {
+		t.delete(key)
+		resp.Headers.Set(cacheStatusHeader, "MISS")
+		return resp
+	}
+
+	entry := &Entry{
+		StatusCode:  resp.StatusLine.StatusCode,
+		Reason:      resp.StatusLine.Reason,
+		Headers:     resp.Headers.Clone(),
+		Vary:        make(map[string]string),
+		RequestTime: requestTime,
+	}
+	entry.Headers.Delete("Transfer-Encoding")
+
+	for _, name := range resp.Headers.List("Vary") {
+		entry.Vary[strings.ToLower(name)], _ = req.Headers.Get(name)
+	}
+
+	resp.Headers.Set(cacheStatusHeader, "MISS")
+	resp.BodyReader = &teeBody{
+		ReadCloser: resp.BodyReader,
+		limit:      t.maxEntryBytes(),
+		done: func(body []byte) {
+			entry.Body = body
+			entry.ResponseTime = t.clock()
+			entry.Headers.Set("Content-Length", strconv.Itoa(len(body)))
+			t.set(key, entry)
+		},
+	}
+
+	return resp
+}
+
+// canStore follows RFC 9111 section 3 for a shared cache.
+func canStore(req *client.Request, resp *response.Response) bool {
+	if !slices.Contains(cacheableStatus, resp.StatusLine.StatusCode) {
+		return false
+	}
+
+	cc := cacheControl(resp.Headers)
+	if cc.has("no-store") || cc.has("private") {
+		return false
+	}
+	if resp.Headers.ContainsToken("Vary", "*") {
+		return false
+	}
+
+	_, authorized := req.Headers.Get("Authorization")
+	if authorized && !cc.has("public") && !cc.has("s-maxage") &&
+		!cc.has("must-revalidate") {
+		return false
+	}
+
+	_, expires := resp.Headers.Get("Expires")
+	_, etag := resp.Headers.Get("ETag")
+	_, lastModified := resp.Headers.Get("Last-Modified")
+	return cc.has("max-age") || cc.has("s-maxage") || expires ||
+		etag || lastModified
+}
+
+// hit builds a response from entry, or a 304 when the entry satisfies the
+// client's own conditional request. Each hit gets its own copy of the
+// header fields and its own body reader.
+func (t *Transport) hit(
+	req *client.Request,
+	entry *Entry,
+	now time.Time,
+) *response.Response {
+	h := entry.Headers.Clone()
+	h.Set("Age", strconv.Itoa(int(entry.age(now)/time.Second)))
+	h.Set(cacheStatusHeader, "HIT")
+
+	statusCode, reason, body := entry.StatusCode, entry.Reason, entry.Body
+	if entry.StatusCode == response.OK && notModified(req.Headers, entry) {
+		statusCode = response.NOTMODIFIED
+		reason = response.StatusText(statusCode)
+		body = nil
+		h.Delete("Content-Length")
+	}
+
+	return &response.Response{
+		StatusLine: response.StatusLine{
+			HttpVersion: "1.1",
+			StatusCode:  statusCode,
+			Reason:      reason,
+		},
+		Headers:    h,
+		Body:       body,
+		BodyReader: io.NopCloser(bytes.NewReader(body)),
+		Trailers:   headers.NewHeaders(),
+	}
+}
+
+// notModified evaluates the client's If-None-Match, or failing that its
+// If-Modified-Since, against entry (RFC 9110 section 13.2.2).
+func notModified(req headers.Headers, entry *Entry) bool {
+	if _, ok := req.Get("If-None-Match"); ok {
+		etag, ok := entry.Headers.Get("ETag")
+		if !ok {
+			return false
+		}
+		for _, tag := range req.List("If-None-Match") {
+			if tag == "*" || sameETag(tag, etag) {
+				return true
+			}
+		}
+		return false
+	}
+
+	since, ok, err := req.Time("If-Modified-Since")
+	if !ok || err != nil {
+		return false
+	}
+	modified, ok, err := entry.Headers.Time("Last-Modified")
+	if !ok || err != nil {
+		return false
+	}
+	return !modified.After(since)
+}
+
+// sameETag is the weak comparison of RFC 9110 section 8.8.3.2.
+func sameETag(a, b string) bool {
+	return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
+
+func (t *Transport) get(key string) (*Entry, bool) {
+	if t.Memory != nil {
+		entry, ok := t.Memory.Get(key)
+		if ok {
+			return entry, true
+		}
+	}
+	if t.Disk == nil {
+		return nil, false
+	}
+
+	entry, ok := t.Disk.Get(key)
+	if ok && t.Memory != nil {
+		_ = t.Memory.Set(key, entry)
+	}
+	return entry, ok
+}
+
+func (t *Transport) set(key string, entry *Entry) {
+	for _, store := range []Store{t.Memory, t.Disk} {
+		if store == nil {
+			continue
+		}
+		err := store.Set(key, entry)
+		if err != nil {
+			t.logger().Printf("cache.set: %s\n", err)
+		}
+	}
+}
+
+func (t *Transport) delete(key string) {
+	if t.Memory != nil {
+		t.Memory.Delete(key)
+	}
+	if t.Disk != nil {
+		t.Disk.Delete(key)
+	}
+}
+
+func (t *Transport) next() client.RoundTripper {
+	if t.Next != nil {
+		return t.Next
+	}
+	return client.DefaultTransport
+}
+
+func (t *Transport) maxEntryBytes() int {
+	if t.MaxEntryBytes == 0 {
+		return defaultMaxEntryBytes
+	}
+	return t.MaxEntryBytes
+}
+
+func (t *Transport) clock() time.Time {
+	if t.now != nil {
+		return t.now()
+	}
+	return time.Now()
+}
+
+func (t *Transport) logger() *log.Logger {
+	if t.Logger != nil {
+		return t.Logger
+	}
+	return log.Default()
+}
+
+// teeBody keeps a copy of the body as it is read and hands it to done at
+// EOF. Bodies growing past limit are not kept.
+type teeBody struct {
+	io.ReadCloser
+	limit int
+	done  func(body []byte)
+
+	buf      bytes.Buffer
+	tooLarge bool
+	finished bool
+}
+
+func (tb *teeBody) Read(p []byte) (int, error) {
+	n, err := tb.ReadCloser.Read(p)
+	if !tb.tooLarge {
+		if tb.buf.Len()+n > tb.limit {
+			tb.tooLarge = true
+			tb.buf = bytes.Buffer{}
+		} else {
+			tb.buf.Write(p[:n])
+		}
+	}
+
+	if err == io.EOF && !tb.finished {
+		tb.finished = true
+		if !tb.tooLarge {
+			tb.done(tb.buf.Bytes())
+		}
+	}
+
+	return n, err
+}
@@ -0,0 +1,374 @@
+package cache
+
+import (
+	"io"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/davidw1457/httpfromtcp/internal/client"
+	"github.com/davidw1457/httpfromtcp/internal/headers"
+	"github.com/davidw1457/httpfromtcp/internal/request"
+	"github.com/davidw1457/httpfromtcp/internal/response"
+	"github.com/davidw1457/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var base = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func TestMaxAge(t *testing.T) {
+	o := startOrigin(t, func(w *response.Writer, req *request.Request, n int64) {
+		writeBody(w, "hello", "Cache-Control", "max-age=60")
+	})
+	c, clock := newTestCache(o, 1<<20)
+
+	// Test: First request is a miss
+	resp, body := get(t, c, o.url, nil)
+	assert.Equal(t, "hello", body)
+	assert.Equal(t, "MISS", header(resp, "X-Cache"))
+
+	// Test: Fresh entry is served from the cache with its age
+	*clock = clock.Add(10 * time.Second)
+	resp, body = get(t, c, o.url, nil)
+	assert.Equal(t, "hello", body)
+	assert.Equal(t, "HIT", header(resp, "X-Cache"))
+	assert.Equal(t, "10", header(resp, "Age"))
+	assert.Equal(t, "5", header(resp, "Content-Length"))
+	assert.Equal(t, int64(1), o.requests.Load())
+
+	// Test: Request max-age lower than the age bypasses the entry
+	resp, _ = get(t, c, o.url, map[string]string{"Cache-Control": "max-age=5"})
+	assert.Equal(t, "MISS", header(resp, "X-Cache"))
+	assert.Equal(t, int64(2), o.requests.Load())
+
+	// Test: Expired entry is fetched again
+	*clock = clock.Add(61 * time.Second)
+	resp, _ = get(t, c, o.url, nil)
+	assert.Equal(t, "MISS", header(resp, "X-Cache"))
+	assert.Equal(t, int64(3), o.requests.Load())
+}
+
+func TestSharedMaxAge(t *testing.T) {
+	o := startOrigin(t, func(w *response.Writer, req *request.Request, n int64) {
+		writeBody(w, "shared", "Cache-Control", "max-age=1, s-maxage=100")
+	})
+	c, clock := newTestCache(o, 1<<20)
+
+	// Test: s-maxage wins over max-age in a shared cache
+	get(t, c, o.url, nil)
+	*clock = clock.Add(50 * time.Second)
+	resp, _ := get(t, c, o.url, nil)
+	assert.Equal(t, "HIT", header(resp, "X-Cache"))
+	assert.Equal(t, int64(1), o.requests.Load())
+}
+
+func TestExpires(t *testing.T) {
+	o := startOrigin(t, func(w *response.Writer, req *request.Request, n int64) {
+		writeBody(w, "expires",
+			"Date", headers.FormatTime(base),
+			"Expires", headers.FormatTime(base.Add(30*time.Second)),
+		)
+	})
+	c, clock := newTestCache(o, 1<<20)
+
+	// Test: Entry is fresh until Expires
+	get(t, c, o.url, nil)
+	*clock = clock.Add(20 * time.Second)
+	resp, _ := get(t, c, o.url, nil)
+	assert.Equal(t, "HIT", header(resp, "X-Cache"))
+
+	// Test: Entry is stale after Expires
+	*clock = clock.Add(20 * time.Second)
+	resp, _ = get(t, c, o.url, nil)
+	assert.Equal(t, "MISS", header(resp, "X-Cache"))
+	assert.Equal(t, int64(2), o.requests.Load())
+}
+
+func TestNotStored(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  []string
+		request map[string]string
+	}{
+		{"no-store", []string{"Cache-Control", "no-store, max-age=60"}, nil},
+		{"private", []string{"Cache-Control", "private, max-age=60"}, nil},
+		{"vary star", []string{"Cache-Control", "max-age=60", "Vary", "*"}, nil},
+		{"no freshness", nil, nil},
+		{
+			"authorization",
+			[]string{"Cache-Control", "max-age=60"},
+			map[string]string{"Authorization": "Bearer x"},
+		},
+		{
+			"request no-store",
+			[]string{"Cache-Control", "max-age=60"},
+			map[string]string{"Cache-Control": "no-store"},
+		},
+	}
+
+	for _, tt := range tests {
+		// Test: Response is not stored
+		o := startOrigin(t, func(w *response.Writer, req *request.Request, n int64) {
+			writeBody(w, "secret", tt.header...)
+		})
+		c, _ := newTestCache(o, 1<<20)
+
+		for range 2 {
+			resp, body := get(t, c, o.url, tt.request)
+			assert.Equal(t, "secret", body, tt.name)
+			assert.Equal(t, "MISS", header(resp, "X-Cache"), tt.name)
+		}
+		assert.Equal(t, int64(2), o.requests.Load(), tt.name)
+	}
+}
+
+func TestETagRevalidation(t *testing.T) {
+	o := startOrigin(t, func(w *response.Writer, req *request.Request, n int64) {
+		if inm, _ := req.Headers.Get("If-None-Match"); inm == `"v1"` {
+			writeNotModified(w, "Cache-Control", "max-age=10")
+			return
+		}
+		writeBody(w, "tagged", "Cache-Control", "max-age=10", "ETag", `"v1"`)
+	})
+	c, clock := newTestCache(o, 1<<20)
+
+	get(t, c, o.url, nil)
+	*clock = clock.Add(20 * time.Second)
+
+	// Test: Stale entry is revalidated and served on 304
+	resp, body := get(t, c, o.url, nil)
+	assert.Equal(t, "tagged", body)
+	assert.Equal(t, "HIT", header(resp, "X-Cache"))
+	assert.Equal(t, "0", header(resp, "Age"))
+	assert.Equal(t, int64(2), o.requests.Load())
+
+	// Test: Revalidated entry is fresh again
+	*clock = clock.Add(5 * time.Second)
+	resp, _ = get(t, c, o.url, nil)
+	assert.Equal(t, "HIT", header(resp, "X-Cache"))
+	assert.Equal(t, int64(2), o.requests.Load())
+
+	// Test: Request no-cache forces revalidation
+	resp, _ = get(t, c, o.url, map[string]string{"Cache-Control": "no-cache"})
+	assert.Equal(t, "HIT", header(resp, "X-Cache"))
+	assert.Equal(t, int64(3), o.requests.Load())
+}
+
+func TestClientConditional(t *testing.T) {
+	o := startOrigin(t, func(w *response.Writer, req *request.Request, n int64) {
+		tags := req.Headers.List("If-None-Match")
+		switch {
+		case slices.Contains(tags, `"v2"`):
+			writeNotModified(w, "ETag", `"v2"`)
+		case slices.Contains(tags, `"v1"`):
+			writeNotModified(w, "ETag", `"v1"`, "Cache-Control", "max-age=10")
+		default:
+			writeBody(w, "tagged", "Cache-Control", "max-age=10", "ETag", `"v1"`)
+		}
+	})
+	c, clock := newTestCache(o, 1<<20)
+
+	get(t, c, o.url, nil)
+
+	// Test: Fresh entry matching the client's validator is a 304
+	resp, body := get(t, c, o.url, map[string]string{"If-None-Match": `"v1"`})
+	assert.Equal(t, response.NOTMODIFIED, resp.StatusLine.StatusCode)
+	assert.Empty(t, body)
+	assert.Equal(t, "HIT", header(resp, "X-Cache"))
+
+	// Test: Entry not matching the client's validator is served in full
+	resp, body = get(t, c, o.url, map[string]string{"If-None-Match": `"v0"`})
+	assert.Equal(t, response.OK, resp.StatusLine.StatusCode)
+	assert.Equal(t, "tagged", body)
+	assert.Equal(t, int64(1), o.requests.Load())
+
+	// Test: Revalidation sends the client's validator with the entry's
+	*clock = clock.Add(20 * time.Second)
+	resp, body = get(t, c, o.url, map[string]string{"If-None-Match": `"v2"`})
+	assert.Equal(t, response.NOTMODIFIED, resp.StatusLine.StatusCode)
+	assert.Empty(t, body)
+	assert.Equal(t, "MISS", header(resp, "X-Cache"))
+	assert.Equal(t, int64(2), o.requests.Load())
+
+	// Test: 304 for the entry answers the client's own conditional
+	resp, _ = get(t, c, o.url, map[string]string{"If-None-Match": `"v0"`})
+	assert.Equal(t, response.OK, resp.StatusLine.StatusCode)
+	assert.Equal(t, "HIT", header(resp, "X-Cache"))
+	assert.Equal(t, int64(3), o.requests.Load())
+}
+
+func TestLastModifiedRevalidation(t *testing.T) {
+	lastModified := headers.FormatTime(base.Add(-time.Hour))
+	o := startOrigin(t, func(w *response.Writer, req *request.Request, n int64) {
+		if ims, _ := req.Headers.Get("If-Modified-Since"); ims == lastModified {
+			writeNotModified(w)
+			return
+		}
+		writeBody(w, "dated", "Last-Modified", lastModified)
+	})
+	c, _ := newTestCache(o, 1<<20)
+
+	// Test: Entry without freshness is revalidated every time
+	get(t, c, o.url, nil)
+	for range 2 {
+		resp, body := get(t, c, o.url, nil)
+		assert.Equal(t, "dated", body)
+		assert.Equal(t, "HIT", header(resp, "X-Cache"))
+	}
+	assert.Equal(t, int64(3), o.requests.Load())
+}
+
+func TestVary(t *testing.T) {
+	o := startOrigin(t, func(w *response.Writer, req *request.Request, n int64) {
+		lang, _ := req.Headers.Get("Accept-Language")
+		writeBody(w, "lang="+lang, "Cache-Control", "max-age=60", "Vary", "Accept-Language")
+	})
+	c, _ := newTestCache(o, 1<<20)
+
+	en := map[string]string{"Accept-Language": "en"}
+	fr := map[string]string{"Accept-Language": "fr"}
+
+	// Test: Matching Vary field is a hit
+	get(t, c, o.url, en)
+	resp, body := get(t, c, o.url, en)
+	assert.Equal(t, "lang=en", body)
+	assert.Equal(t, "HIT", header(resp, "X-Cache"))
+
+	// Test: Different Vary field is a miss
+	resp, body = get(t, c, o.url, fr)
+	assert.Equal(t, "lang=fr", body)
+	assert.Equal(t, "MISS", header(resp, "X-Cache"))
+	assert.Equal(t, int64(2), o.requests.Load())
+}
+
+func TestUnsafeMethodInvalidates(t *testing.T) {
+	o := startOrigin(t, func(w *response.Writer, req *request.Request, n int64) {
+		writeBody(w, "data", "Cache-Control", "max-age=60")
+	})
+	c, _ := newTestCache(o, 1<<20)
+
+	get(t, c, o.url, nil)
+
+	// Test: POST removes the entry for its URL
+	req, err := client.NewRequest("POST", o.url, nil)
+	require.NoError(t, err)
+	resp, err := c.RoundTrip(req)
+	require.NoError(t, err)
+	readBody(t, resp)
+
+	resp, _ = get(t, c, o.url, nil)
+	assert.Equal(t, "MISS", header(resp, "X-Cache"))
+	assert.Equal(t, int64(3), o.requests.Load())
+}
+
+func TestDiskStore(t *testing.T) {
+	o := startOrigin(t, func(w *response.Writer, req *request.Request, n int64) {
+		writeBody(w, "persisted", "Cache-Control", "max-age=60")
+	})
+	dir := t.TempDir()
+
+	disk, err := NewDiskStore(dir, 0)
+	require.NoError(t, err)
+	first, _ := newTestCache(o, 1<<20)
+	first.Disk = disk
+	get(t, first, o.url, nil)
+
+	// Test: Entry on disk is served by a cache with an empty memory store
+	second, _ := newTestCache(o, 1<<20)
+	second.Disk = disk
+	resp, body := get(t, second, o.url, nil)
+	assert.Equal(t, "persisted", body)
+	assert.Equal(t, "HIT", header(resp, "X-Cache"))
+	assert.Equal(t, int64(1), o.requests.Load())
+	assert.Positive(t, second.Memory.(*MemoryStore).Bytes())
+
+	// Test: Cache without a memory store uses the disk alone
+	third := &Transport{Next: &client.Transport{}, Disk: disk}
+	third.now = func() time.Time { return base }
+	resp, body = get(t, third, o.url, nil)
+	assert.Equal(t, "persisted", body)
+	assert.Equal(t, "HIT", header(resp, "X-Cache"))
+	assert.Equal(t, int64(1), o.requests.Load())
+}
+
+type origin struct {
+	url      string
+	requests atomic.Int64
+}
+
+func startOrigin(
+	t *testing.T,
+	handler func(w *response.Writer, req *request.Request, n int64),
+) *origin {
+	t.Helper()
+	o := &origin{}
+	s := &server.Server{
+		Addr: "127.0.0.1:0",
+		Handler: func(w *response.Writer, req *request.Request) {
+			handler(w, req, o.requests.Add(1))
+		},
+	}
+	require.NoError(t, s.Start())
+	t.Cleanup(func() { _ = s.Close() })
+	o.url = "http://" + s.ListenAddr().String() + "/resource"
+	return o
+}
+
+func newTestCache(o *origin, maxBytes int) (*Transport, *time.Time) {
+	clock := base
+	c := New(&client.Transport{}, maxBytes)
+	c.now = func() time.Time { return clock }
+	return c, &clock
+}
+
+func get(
+	t *testing.T,
+	c *Transport,
+	url string,
+	h map[string]string,
+) (*response.Response, string) {
+	t.Helper()
+	req, err := client.NewRequest("GET", url, nil)
+	require.NoError(t, err)
+	for k, v := range h {
+		req.Headers.Set(k, v)
+	}
+	resp, err := c.RoundTrip(req)
+	require.NoError(t, err)
+	return resp, readBody(t, resp)
+}
+
+func readBody(t *testing.T, resp *response.Response) string {
+	t.Helper()
+	defer resp.BodyReader.Close()
+	body, err := io.ReadAll(resp.BodyReader)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func header(resp *response.Response, name string) string {
+	value, _ := resp.Headers.Get(name)
+	return value
+}
+
+func writeBody(w *response.Writer, body string, fields ...string) {
+	h := response.GetDefaultHeaders(len(body))
+	for i := 0; i+1 < len(fields); i += 2 {
+		h.Set(fields[i], fields[i+1])
+	}
+	_ = w.WriteStatusLine(response.OK)
+	_ = w.WriteHeaders(h)
+	_, _ = w.WriteBody([]byte(body))
+}
+
+func writeNotModified(w *response.Writer, fields ...string) {
+	h := response.GetDefaultHeaders(0)
+	h.Delete("Content-Length")
+	for i := 0; i+1 < len(fields); i += 2 {
+		h.Set(fields[i], fields[i+1])
+	}
+	_ = w.WriteStatusLine(response.NOTMODIFIED)
+	_ = w.WriteHeaders(h)
+}
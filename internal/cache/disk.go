@@ -0,0 +1,192 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/davidw1457/httpfromtcp/internal/headers"
+	"github.com/davidw1457/httpfromtcp/internal/response"
+)
+
+// DiskStore keeps one JSON file per entry in Dir, named after a hash of the
+// key. Once the files add up to more than MaxBytes, the least recently used
+// ones are removed; zero means no limit. An entry larger than MaxBytes on
+// its own is not stored.
+type DiskStore struct {
+	Dir      string
+	MaxBytes int64
+
+	mu sync.Mutex
+}
+
+// diskEntry is the file format of a DiskStore entry. Header fields are
+// stored as name and value pairs.
+type diskEntry struct {
+	Key          string
+	StatusCode   response.StatusCode
+	Reason       string
+	Headers      [][2]string
+	Body         []byte
+	Vary         map[string]string
+	RequestTime  time.Time
+	ResponseTime time.Time
+}
+
+func NewDiskStore(dir string, maxBytes int64) (*DiskStore, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("cache.NewDiskStore: %w", err)
+	}
+
+	return &DiskStore{Dir: dir, MaxBytes: maxBytes}, nil
+}
+
+// Get marks the entry as recently used by touching its file.
+func (ds *DiskStore) Get(key string) (*Entry, bool) {
+	path := ds.path(key)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+
+	var de diskEntry
+	err = json.Unmarshal(data, &de)
+	if err != nil || de.Key != key {
+		return nil, false
+	}
+
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+
+	h := headers.NewHeaders()
+	for _, field := range de.Headers {
+		h.Add(field[0], field[1])
+	}
+
+	return &Entry{
+		StatusCode:   de.StatusCode,
+		Reason:       de.Reason,
+		Headers:      h,
+		Body:         de.Body,
+		Vary:         de.Vary,
+		RequestTime:  de.RequestTime,
+		ResponseTime: de.ResponseTime,
+	}, true
+}
+
+// Set writes the entry to a temporary file and renames it into place, so
+// readers never see a partial entry.
+func (ds *DiskStore) Set(key string, entry *Entry) error {
+	de := diskEntry{
+		Key:          key,
+		StatusCode:   entry.StatusCode,
+		Reason:       entry.Reason,
+		Body:         entry.Body,
+		Vary:         entry.Vary,
+		RequestTime:  entry.RequestTime,
+		ResponseTime: entry.ResponseTime,
+	}
+	for name, values := range entry.Headers.All() {
+		for _, v := range values {
+			de.Headers = append(de.Headers, [2]string{name, v})
+		}
+	}
+
+	data, err := json.Marshal(de)
+	if err != nil {
+		return fmt.Errorf("DiskStore.Set: %w", err)
+	}
+
+	if ds.MaxBytes > 0 && int64(len(data)) > ds.MaxBytes {
+		ds.Delete(key)
+		return nil
+	}
+
+	tmp, err := os.CreateTemp(ds.Dir, "entry-*.tmp")
+	if err != nil {
+		return fmt.Errorf("DiskStore.Set: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("DiskStore.Set: %w", err)
+	}
+	err = tmp.Close()
+	if err != nil {
+		return fmt.Errorf("DiskStore.Set: %w", err)
+	}
+
+	err = os.Rename(tmp.Name(), ds.path(key))
+	if err != nil {
+		return fmt.Errorf("DiskStore.Set: %w", err)
+	}
+
+	err = ds.evict()
+	if err != nil {
+		return fmt.Errorf("DiskStore.Set: %w", err)
+	}
+
+	return nil
+}
+
+// evict removes the least recently used entries until the rest fit in
+// MaxBytes.
+func (ds *DiskStore) evict() error {
+	if ds.MaxBytes <= 0 {
+		return nil
+	}
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	paths, err := filepath.Glob(filepath.Join(ds.Dir, "*.json"))
+	if err != nil {
+		return fmt.Errorf("evict: %w", err)
+	}
+
+	var files []os.FileInfo
+	var total int64
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		files = append(files, info)
+		total += info.Size()
+	}
+
+	slices.SortFunc(files, func(a, b os.FileInfo) int {
+		return a.ModTime().Compare(b.ModTime())
+	})
+	for _, info := range files {
+		if total <= ds.MaxBytes {
+			break
+		}
+		err = os.Remove(filepath.Join(ds.Dir, info.Name()))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("evict: %w", err)
+		}
+		total -= info.Size()
+	}
+
+	return nil
+}
+
+func (ds *DiskStore) Delete(key string) {
+	_ = os.Remove(ds.path(key))
+}
+
+func (ds *DiskStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(ds.Dir, hex.EncodeToString(sum[:])+".json")
+}
@@ -0,0 +1,48 @@
+package cache
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/davidw1457/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiskStoreEviction(t *testing.T) {
+	entry := &Entry{Headers: headers.NewHeaders(), Body: []byte(strings.Repeat("x", 100))}
+	probe, err := NewDiskStore(t.TempDir(), 0)
+	require.NoError(t, err)
+	require.NoError(t, probe.Set("a", entry))
+	info, err := os.Stat(probe.path("a"))
+	require.NoError(t, err)
+	size := info.Size()
+
+	ds, err := NewDiskStore(t.TempDir(), 2*size)
+	require.NoError(t, err)
+
+	// Test: Entries fit under the cap
+	assert.NoError(t, ds.Set("a", entry))
+	assert.NoError(t, ds.Set("b", entry))
+	_, ok := ds.Get("a")
+	assert.True(t, ok)
+	_, ok = ds.Get("b")
+	assert.True(t, ok)
+
+	// Test: Least recently used entry is evicted first
+	past := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(ds.path("b"), past, past))
+	assert.NoError(t, ds.Set("c", entry))
+	_, ok = ds.Get("b")
+	assert.False(t, ok)
+	_, ok = ds.Get("a")
+	assert.True(t, ok)
+
+	// Test: Entry larger than the cap is not stored
+	large := &Entry{Headers: headers.NewHeaders(), Body: []byte(strings.Repeat("x", 500))}
+	assert.NoError(t, ds.Set("d", large))
+	_, ok = ds.Get("d")
+	assert.False(t, ok)
+}
@@ -0,0 +1,140 @@
+package cache
+
+import (
+	"strings"
+	"time"
+
+	"github.com/davidw1457/httpfromtcp/internal/headers"
+	"github.com/davidw1457/httpfromtcp/internal/response"
+)
+
+// Entry is a stored response together with what is needed to judge its
+// freshness (RFC 9111 section 4.2) and to pick it for a request (Vary).
+type Entry struct {
+	StatusCode response.StatusCode
+	Reason     string
+	Headers    headers.Headers
+	Body       []byte
+	// Vary holds the request header values named by the response's Vary
+	// field, keyed by lowercase field name.
+	Vary         map[string]string
+	RequestTime  time.Time
+	ResponseTime time.Time
+}
+
+// size approximates the memory an entry holds, for the LRU byte cap.
+func (e *Entry) size() int {
+	n := len(e.Body) + len(e.Reason)
+	for name, values := range e.Headers.All() {
+		for _, v := range values {
+			n += len(name) + len(v)
+		}
+	}
+	for k, v := range e.Vary {
+		n += len(k) + len(v)
+	}
+	return n
+}
+
+// freshnessLifetime follows RFC 9111 section 4.2.1 for a shared cache.
+// Without explicit freshness information the entry is always stale, so it
+// is only ever served after revalidation.
+func (e *Entry) freshnessLifetime() time.Duration {
+	cc := cacheControl(e.Headers)
+	if seconds, ok := cc.seconds("s-maxage"); ok {
+		return seconds
+	}
+	if seconds, ok := cc.seconds("max-age"); ok {
+		return seconds
+	}
+
+	expires, ok, err := e.Headers.Time("Expires")
+	if !ok || err != nil {
+		return 0
+	}
+	return expires.Sub(e.date())
+}
+
+// age is current_age from RFC 9111 section 4.2.3.
+func (e *Entry) age(now time.Time) time.Duration {
+	apparentAge := max(0, e.ResponseTime.Sub(e.date()))
+
+	responseDelay := e.ResponseTime.Sub(e.RequestTime)
+	correctedAgeValue := responseDelay
+	seconds, ok, err := e.Headers.Int("Age")
+	if ok && err == nil {
+		correctedAgeValue += time.Duration(seconds) * time.Second
+	}
+
+	correctedInitialAge := max(apparentAge, correctedAgeValue)
+	residentTime := now.Sub(e.ResponseTime)
+
+	return correctedInitialAge + residentTime
+}
+
+func (e *Entry) fresh(now time.Time) bool {
+	if cacheControl(e.Headers).has("no-cache") {
+		return false
+	}
+	return e.freshnessLifetime() > e.age(now)
+}
+
+// date returns the Date field, falling back to when the response arrived.
+func (e *Entry) date() time.Time {
+	date, ok, err := e.Headers.Time("Date")
+	if !ok || err != nil {
+		return e.ResponseTime
+	}
+	return date
+}
+
+func (e *Entry) hasValidators() bool {
+	_, etag := e.Headers.Get("ETag")
+	_, lastModified := e.Headers.Get("Last-Modified")
+	return etag || lastModified
+}
+
+// varyMatches reports whether req selects this entry: every field named
+// by Vary must have the value it had on the request that stored it.
+func (e *Entry) varyMatches(req headers.Headers) bool {
+	for name, value := range e.Vary {
+		got, _ := req.Get(name)
+		if got != value {
+			return false
+		}
+	}
+	return true
+}
+
+// directives holds Cache-Control directives by lowercase name; directives
+// without an argument map to an empty string.
+type directives map[string]string
+
+func cacheControl(h headers.Headers) directives {
+	cc := make(directives)
+	for _, directive := range h.List("Cache-Control") {
+		name, arg, _ := strings.Cut(directive, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		cc[name] = strings.Trim(strings.TrimSpace(arg), `"`)
+	}
+
+	return cc
+}
+
+func (cc directives) has(name string) bool {
+	_, ok := cc[name]
+	return ok
+}
+
+func (cc directives) seconds(name string) (time.Duration, bool) {
+	arg, ok := cc[name]
+	if !ok {
+		return 0, false
+	}
+
+	seconds, err := headers.ParseInt(arg)
+	if err != nil {
+		return 0, true
+	}
+	return time.Duration(seconds) * time.Second, true
+}
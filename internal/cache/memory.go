@@ -0,0 +1,98 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+)
+
+// MemoryStore keeps entries in memory and evicts the least recently used
+// ones once their total size exceeds MaxBytes. An entry larger than
+// MaxBytes on its own is not stored. The zero value stores nothing until
+// MaxBytes is set.
+type MemoryStore struct {
+	MaxBytes int
+
+	mu    sync.Mutex
+	lru   *list.List
+	items map[string]*list.Element
+	bytes int
+}
+
+type memoryItem struct {
+	key   string
+	entry *Entry
+	size  int
+}
+
+func NewMemoryStore(maxBytes int) *MemoryStore {
+	return &MemoryStore{
+		MaxBytes: maxBytes,
+		lru:      list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (ms *MemoryStore) Get(key string) (*Entry, bool) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	elem, ok := ms.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	ms.lru.MoveToFront(elem)
+	return elem.Value.(*memoryItem).entry, true
+}
+
+func (ms *MemoryStore) Set(key string, entry *Entry) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if ms.items == nil {
+		ms.lru = list.New()
+		ms.items = make(map[string]*list.Element)
+	}
+	ms.remove(key)
+
+	size := entry.size() + len(key)
+	if size > ms.MaxBytes {
+		return nil
+	}
+
+	ms.items[key] = ms.lru.PushFront(&memoryItem{key: key, entry: entry, size: size})
+	ms.bytes += size
+
+	for ms.bytes > ms.MaxBytes {
+		oldest := ms.lru.Back()
+		ms.remove(oldest.Value.(*memoryItem).key)
+	}
+
+	return nil
+}
+
+func (ms *MemoryStore) Delete(key string) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.remove(key)
+}
+
+// Bytes returns the total size of the stored entries.
+func (ms *MemoryStore) Bytes() int {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	return ms.bytes
+}
+
+func (ms *MemoryStore) remove(key string) {
+	elem, ok := ms.items[key]
+	if !ok {
+		return
+	}
+
+	ms.lru.Remove(elem)
+	delete(ms.items, key)
+	ms.bytes -= elem.Value.(*memoryItem).size
+}
@@ -0,0 +1,40 @@
+package cache
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/davidw1457/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStoreEviction(t *testing.T) {
+	ms := NewMemoryStore(250)
+	entry := func(size int) *Entry {
+		return &Entry{Headers: headers.NewHeaders(), Body: []byte(strings.Repeat("x", size))}
+	}
+
+	// Test: Entries fit under the cap
+	assert.NoError(t, ms.Set("a", entry(99)))
+	assert.NoError(t, ms.Set("b", entry(99)))
+	assert.Equal(t, 200, ms.Bytes())
+
+	// Test: Least recently used entry is evicted first
+	_, ok := ms.Get("a")
+	assert.True(t, ok)
+	assert.NoError(t, ms.Set("c", entry(99)))
+	_, ok = ms.Get("b")
+	assert.False(t, ok)
+	_, ok = ms.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 200, ms.Bytes())
+
+	// Test: Entry larger than the cap is not stored
+	assert.NoError(t, ms.Set("d", entry(300)))
+	_, ok = ms.Get("d")
+	assert.False(t, ok)
+
+	// Test: Delete frees the entry's bytes
+	ms.Delete("a")
+	assert.Equal(t, 100, ms.Bytes())
+}
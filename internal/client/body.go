@@ -0,0 +1,73 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// maxDrainBytes is how much of an unread body Close discards to keep the
+// connection for reuse; larger remainders close the connection instead.
+const maxDrainBytes = 256 * 1024
+
+// pooledBody replaces the BodyReader of a response handed to callers. Once
+// the body has been read to the end, or is drained by Close, the connection
+// goes back to the pool if the response allows it, and is closed otherwise.
+type pooledBody struct {
+	body      io.ReadCloser
+	transport *Transport
+	pc        *persistConn
+	reusable  bool
+	released  bool
+}
+
+func (pb *pooledBody) Read(p []byte) (int, error) {
+	if pb.released {
+		return 0, io.EOF
+	}
+
+	n, err := pb.body.Read(p)
+	if errors.Is(err, io.EOF) {
+		pb.release(true)
+	} else if err != nil {
+		pb.release(false)
+	}
+
+	return n, err
+}
+
+func (pb *pooledBody) Close() error {
+	if pb.released {
+		return nil
+	}
+
+	if !pb.reusable {
+		pb.release(false)
+		return nil
+	}
+
+	_, err := io.CopyN(io.Discard, pb.body, maxDrainBytes)
+	if errors.Is(err, io.EOF) {
+		pb.release(true)
+		return nil
+	}
+
+	pb.release(false)
+	if err != nil {
+		return fmt.Errorf("pooledBody.Close: %w", err)
+	}
+	return nil
+}
+
+func (pb *pooledBody) release(complete bool) {
+	if pb.released {
+		return
+	}
+	pb.released = true
+
+	if complete && pb.reusable {
+		pb.transport.putIdle(pb.pc)
+		return
+	}
+	pb.pc.conn.Close()
+}
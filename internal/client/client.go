@@ -0,0 +1,201 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/davidw1457/httpfromtcp/internal/headers"
+	"github.com/davidw1457/httpfromtcp/internal/response"
+)
+
+const userAgent = "httpfromtcp"
+
+// Client sends HTTP/1.1 requests through its Transport, which defaults to
+// DefaultTransport.
+type Client struct {
+	Transport RoundTripper
+	// Timeout bounds the whole exchange, from dialing to reading the
+	// response body. Zero means no timeout.
+	Timeout time.Duration
+}
+
+var DefaultClient = &Client{}
+
+// Request is an outgoing request. Headers are sent as given, overriding
+// the Host, User-Agent and body framing fields the client adds.
+type Request struct {
+	Method  string
+	URL     *url.URL
+	Headers headers.Headers
+	Body    io.Reader
+	// ContentLength is the size of Body, or -1 if unknown, in which case
+	// the body is sent chunked.
+	ContentLength int64
+
+	deadline time.Time
+}
+
+// NewRequest builds a request for rawURL. The content length is known for
+// *bytes.Buffer, *bytes.Reader and *strings.Reader bodies.
+func NewRequest(method string, rawURL string, body io.Reader) (*Request, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("client.NewRequest: %w", err)
+	}
+
+	req := &Request{
+		Method:        method,
+		URL:           u,
+		Headers:       headers.NewHeaders(),
+		Body:          body,
+		ContentLength: -1,
+	}
+
+	switch b := body.(type) {
+	case nil:
+		req.ContentLength = 0
+	case *bytes.Buffer:
+		req.ContentLength = int64(b.Len())
+	case *bytes.Reader:
+		req.ContentLength = int64(b.Len())
+	case *strings.Reader:
+		req.ContentLength = int64(b.Len())
+	}
+
+	return req, nil
+}
+
+func Get(rawURL string) (*response.Response, error) {
+	return DefaultClient.Get(rawURL)
+}
+
+func (c *Client) Get(rawURL string) (*response.Response, error) {
+	req, err := NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("client.Get: %w", err)
+	}
+
+	return c.Do(req)
+}
+
+// Do sends req and returns once the final response's headers are read. The
+// body streams from Response.BodyReader, which the caller must close.
+func (c *Client) Do(req *Request) (*response.Response, error) {
+	if c.Timeout > 0 {
+		req.deadline = time.Now().Add(c.Timeout)
+	}
+
+	transport := c.Transport
+	if transport == nil {
+		transport = DefaultTransport
+	}
+
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, fmt.Errorf("client.Do: %w", err)
+	}
+
+	return resp, nil
+}
+
+func writeRequest(conn net.Conn, req *Request) error {
+	w := bufio.NewWriter(conn)
+
+	target := req.URL.RequestURI()
+	_, err := fmt.Fprintf(w, "%s %s HTTP/1.1\r\n", req.Method, target)
+	if err != nil {
+		return fmt.Errorf("writeRequest: %w", err)
+	}
+
+	h := headers.NewHeaders()
+	h.Set("Host", req.URL.Host)
+	h.Set("User-Agent", userAgent)
+	chunked := false
+	switch {
+	case req.Body == nil:
+		if carriesBody(req.Method) {
+			h.Set("Content-Length", "0")
+		}
+	case req.ContentLength >= 0:
+		h.Set("Content-Length", strconv.FormatInt(req.ContentLength, 10))
+	default:
+		h.Set("Transfer-Encoding", "chunked")
+		chunked = true
+	}
+	h.Merge(req.Headers)
+	err = h.Validate()
+	if err != nil {
+		return fmt.Errorf("writeRequest: %w", err)
+	}
+
+	for k, values := range h.All() {
+		for _, v := range values {
+			_, err = fmt.Fprintf(w, "%s: %s\r\n", k, v)
+			if err != nil {
+				return fmt.Errorf("writeRequest: %w", err)
+			}
+		}
+	}
+	_, err = w.WriteString("\r\n")
+	if err != nil {
+		return fmt.Errorf("writeRequest: %w", err)
+	}
+
+	if req.Body != nil {
+		if chunked {
+			err = writeChunked(w, req.Body)
+		} else {
+			_, err = io.CopyN(w, req.Body, req.ContentLength)
+		}
+		if err != nil {
+			return fmt.Errorf("writeRequest: %w", err)
+		}
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return fmt.Errorf("writeRequest: %w", err)
+	}
+
+	return nil
+}
+
+// carriesBody reports whether requests with method are expected to have
+// content, so that an empty one is still framed with Content-Length: 0
+// (RFC 9110 section 8.6).
+func carriesBody(method string) bool {
+	return method == "POST" || method == "PUT" || method == "PATCH"
+}
+
+func writeChunked(w *bufio.Writer, body io.Reader) error {
+	p := make([]byte, 32*1024)
+	for {
+		n, err := body.Read(p)
+		if n > 0 {
+			_, werr := fmt.Fprintf(w, "%x\r\n%s\r\n", n, p[:n])
+			if werr != nil {
+				return fmt.Errorf("writeChunked: %w", werr)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("writeChunked: %w", err)
+		}
+	}
+
+	_, err := w.WriteString("0\r\n\r\n")
+	if err != nil {
+		return fmt.Errorf("writeChunked: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,188 @@
+package client
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/davidw1457/httpfromtcp/internal/headers"
+	"github.com/davidw1457/httpfromtcp/internal/request"
+	"github.com/davidw1457/httpfromtcp/internal/response"
+	"github.com/davidw1457/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientBodies(t *testing.T) {
+	baseURL := startServer(t, func(w *response.Writer, req *request.Request) {
+		switch req.RequestLine.RequestTarget {
+		case "/length":
+			body := []byte("hello")
+			_ = w.WriteStatusLine(response.OK)
+			h := response.GetDefaultHeaders(len(body))
+			h.Set("X-Test", "yes")
+			_ = w.WriteHeaders(h)
+			_, _ = w.WriteBody(body)
+		case "/chunked":
+			h := headers.NewHeaders()
+			h.Set("Transfer-Encoding", "chunked")
+			h.Set("Trailer", "X-Checksum")
+			_ = w.WriteStatusLine(response.OK)
+			_ = w.WriteHeaders(h)
+			_, _ = w.WriteChunkedBody([]byte("hello, "))
+			_, _ = w.WriteChunkedBody([]byte("world"))
+			_, _ = w.WriteChunkedBodyDone()
+			h.Set("X-Checksum", "abc123")
+			_ = w.WriteTrailers(h)
+		case "/close":
+			_ = w.WriteStatusLine(response.OK)
+			_ = w.WriteHeaders(headers.NewHeaders())
+			_, _ = w.WriteBody([]byte("until close"))
+		case "/empty":
+			_ = w.WriteStatusLine(response.NOCONTENT)
+			_ = w.WriteHeaders(headers.NewHeaders())
+		}
+	})
+
+	// Test: Content-Length body and headers
+	resp, body := get(t, baseURL+"/length")
+	assert.Equal(t, response.OK, resp.StatusLine.StatusCode)
+	assert.Equal(t, "OK", resp.StatusLine.Reason)
+	assert.Equal(t, "1.1", resp.StatusLine.HttpVersion)
+	value, _ := resp.Headers.Get("X-Test")
+	assert.Equal(t, "yes", value)
+	assert.Equal(t, "hello", body)
+
+	// Test: Chunked body with trailers
+	resp, body = get(t, baseURL+"/chunked")
+	assert.Equal(t, "hello, world", body)
+	value, _ = resp.Trailers.Get("X-Checksum")
+	assert.Equal(t, "abc123", value)
+
+	// Test: Body delimited by the connection closing
+	_, body = get(t, baseURL+"/close")
+	assert.Equal(t, "until close", body)
+
+	// Test: 204 has no body
+	resp, body = get(t, baseURL+"/empty")
+	assert.Equal(t, response.NOCONTENT, resp.StatusLine.StatusCode)
+	assert.Empty(t, body)
+}
+
+func TestClientRequestBody(t *testing.T) {
+	baseURL := startServer(t, func(w *response.Writer, req *request.Request) {
+		te, _ := req.Headers.Get("Transfer-Encoding")
+		body := []byte(req.RequestLine.Method + " " + te + " " + string(req.Body))
+		_ = w.WriteStatusLine(response.OK)
+		_ = w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		_, _ = w.WriteBody(body)
+	})
+
+	// Test: Body of known length
+	req, err := NewRequest("POST", baseURL+"/", strings.NewReader("known"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), req.ContentLength)
+	resp, err := DefaultClient.Do(req)
+	require.NoError(t, err)
+	out := readBody(t, resp)
+	assert.Equal(t, "POST  known", out)
+
+	// Test: Body of unknown length is sent chunked
+	req, err = NewRequest("PUT", baseURL+"/", io.MultiReader(
+		strings.NewReader("un"),
+		strings.NewReader("known"),
+	))
+	require.NoError(t, err)
+	resp, err = DefaultClient.Do(req)
+	require.NoError(t, err)
+	out = readBody(t, resp)
+	assert.Equal(t, "PUT chunked unknown", out)
+
+	// Test: Empty POST is framed with Content-Length: 0
+	baseURL = startServer(t, func(w *response.Writer, req *request.Request) {
+		body := []byte(strings.Join(req.Headers.Values("Content-Length"), ","))
+		_ = w.WriteStatusLine(response.OK)
+		_ = w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		_, _ = w.WriteBody(body)
+	})
+	req, err = NewRequest("POST", baseURL+"/", nil)
+	require.NoError(t, err)
+	resp, err = DefaultClient.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, "0", readBody(t, resp))
+
+	// Test: GET without a body has no Content-Length
+	_, out = get(t, baseURL+"/")
+	assert.Empty(t, out)
+}
+
+func TestClientInterimResponse(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = request.RequestFromReader(conn)
+		_, _ = conn.Write([]byte(
+			"HTTP/1.1 103 Early Hints\r\nLink: </style.css>\r\n\r\n" +
+				"HTTP/1.1 200 OK\r\nContent-Length: 4\r\n\r\ndone",
+		))
+	}()
+
+	// Test: 1xx responses are skipped
+	resp, body := get(t, "http://"+ln.Addr().String()+"/")
+	assert.Equal(t, response.OK, resp.StatusLine.StatusCode)
+	require.Len(t, resp.Interim, 1)
+	assert.Equal(t, response.EARLYHINTS, resp.Interim[0].StatusLine.StatusCode)
+	assert.Equal(t, "done", body)
+}
+
+func TestClientErrors(t *testing.T) {
+	// Test: Unsupported scheme
+	_, err := Get("ftp://127.0.0.1/")
+	assert.ErrorIs(t, err, ErrUnsupportedScheme)
+
+	// Test: Malformed status line
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = request.RequestFromReader(conn)
+		_, _ = conn.Write([]byte("HTTP/1.1 OK\r\n\r\n"))
+	}()
+	_, err = Get("http://" + ln.Addr().String() + "/")
+	assert.ErrorIs(t, err, response.ErrMalformedStatusLine)
+}
+
+func startServer(t *testing.T, handler server.Handler) string {
+	t.Helper()
+	s := &server.Server{Addr: "127.0.0.1:0", Handler: handler}
+	require.NoError(t, s.Start())
+	t.Cleanup(func() { _ = s.Close() })
+	return "http://" + s.ListenAddr().String()
+}
+
+func get(t *testing.T, url string) (*response.Response, string) {
+	t.Helper()
+	resp, err := Get(url)
+	require.NoError(t, err)
+	return resp, readBody(t, resp)
+}
+
+func readBody(t *testing.T, resp *response.Response) string {
+	t.Helper()
+	defer resp.BodyReader.Close()
+	body, err := io.ReadAll(resp.BodyReader)
+	require.NoError(t, err)
+	return string(body)
+}
@@ -0,0 +1,7 @@
+package client
+
+import "errors"
+
+// ErrUnsupportedScheme is returned, wrapped, for URLs other than http and
+// https. Errors parsing the response are those of the response package.
+var ErrUnsupportedScheme = errors.New("unsupported url scheme")
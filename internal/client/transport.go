@@ -0,0 +1,335 @@
+package client
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/davidw1457/httpfromtcp/internal/response"
+)
+
+const (
+	defaultMaxIdlePerHost = 2
+	defaultIdleTimeout    = 90 * time.Second
+)
+
+// RoundTripper sends a single request and returns its response.
+type RoundTripper interface {
+	RoundTrip(req *Request) (*response.Response, error)
+}
+
+// Transport is a RoundTripper that keeps connections open after a response
+// has been read and reuses them for later requests to the same scheme,
+// host and port. A connection is only pooled when the response body was
+// read to the end and neither side asked to close it.
+type Transport struct {
+	// TLSConfig is used for https URLs. ServerName defaults to the URL host.
+	TLSConfig *tls.Config
+	// DialTimeout bounds connecting, including the TLS handshake. Zero
+	// means no timeout.
+	DialTimeout time.Duration
+	// MaxIdlePerHost defaults to 2; a negative value disables pooling.
+	// IdleTimeout defaults to 90 seconds.
+	MaxIdlePerHost int
+	IdleTimeout    time.Duration
+
+	mu   sync.Mutex
+	idle map[string][]*persistConn
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+var DefaultTransport = &Transport{}
+
+// PoolStats counts requests served on a pooled connection (Hits) and on a
+// newly dialed one (Misses), and the connections currently idle.
+type PoolStats struct {
+	Hits   int64
+	Misses int64
+	Idle   int
+}
+
+type persistConn struct {
+	key       string
+	conn      net.Conn
+	reader    *response.Reader
+	idleSince time.Time
+	// idleRead receives the result of the read that watches the connection
+	// while it sits in the pool.
+	idleRead chan error
+}
+
+func (t *Transport) RoundTrip(req *Request) (*response.Response, error) {
+	for {
+		pc, reused, err := t.getConn(req.URL, req.deadline)
+		if err != nil {
+			return nil, fmt.Errorf("Transport.RoundTrip: %w", err)
+		}
+
+		resp, err := t.roundTrip(pc, req)
+		if err != nil {
+			pc.conn.Close()
+			if reused && req.Body == nil && idempotent(req.Method) &&
+				isStaleConnError(err) {
+				continue
+			}
+			return nil, fmt.Errorf("Transport.RoundTrip: %w", err)
+		}
+
+		return resp, nil
+	}
+}
+
+func (t *Transport) roundTrip(
+	pc *persistConn,
+	req *Request,
+) (*response.Response, error) {
+	_ = pc.conn.SetDeadline(req.deadline)
+
+	err := writeRequest(pc.conn, req)
+	if err != nil {
+		return nil, fmt.Errorf("roundTrip: %w", err)
+	}
+
+	resp, err := pc.reader.ReadResponse(req.Method)
+	if err != nil {
+		return nil, fmt.Errorf("roundTrip: %w", err)
+	}
+
+	reusable := resp.KeepAlive() &&
+		!req.Headers.ContainsToken("Connection", "close")
+	resp.BodyReader = &pooledBody{
+		body:      resp.BodyReader,
+		transport: t,
+		pc:        pc,
+		reusable:  reusable,
+	}
+
+	return resp, nil
+}
+
+// isStaleConnError reports whether err means the server closed a pooled
+// connection before reading the request, so it is safe to send again.
+func isStaleConnError(err error) bool {
+	return errors.Is(err, io.EOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE)
+}
+
+// idempotent reports whether a request with method may be sent again
+// after the connection failed under it (RFC 9110 section 9.2.2).
+func idempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	default:
+		return false
+	}
+}
+
+// Stats returns the pool counters.
+func (t *Transport) Stats() PoolStats {
+	t.mu.Lock()
+	idle := 0
+	for _, conns := range t.idle {
+		idle += len(conns)
+	}
+	t.mu.Unlock()
+
+	return PoolStats{
+		Hits:   t.hits.Load(),
+		Misses: t.misses.Load(),
+		Idle:   idle,
+	}
+}
+
+// CloseIdleConnections closes every pooled connection.
+func (t *Transport) CloseIdleConnections() {
+	t.mu.Lock()
+	idle := t.idle
+	t.idle = nil
+	t.mu.Unlock()
+
+	for _, conns := range idle {
+		for _, pc := range conns {
+			pc.conn.Close()
+		}
+	}
+}
+
+// getConn returns a pooled connection for u or dials a new one, giving up
+// at deadline if it is set.
+func (t *Transport) getConn(
+	u *url.URL,
+	deadline time.Time,
+) (*persistConn, bool, error) {
+	key := u.Scheme + "://" + hostPort(u)
+
+	for {
+		pc := t.takeIdle(key)
+		if pc == nil {
+			break
+		}
+		if pc.healthy() {
+			t.hits.Add(1)
+			return pc, true, nil
+		}
+		pc.conn.Close()
+	}
+
+	t.misses.Add(1)
+	conn, err := t.dial(u, deadline)
+	if err != nil {
+		return nil, false, fmt.Errorf("getConn: %w", err)
+	}
+
+	reader := response.NewReader(conn)
+	reader.StreamBody = true
+
+	return &persistConn{key: key, conn: conn, reader: reader}, false, nil
+}
+
+// takeIdle pops the most recently used connection for key, closing any
+// that have been idle longer than IdleTimeout.
+func (t *Transport) takeIdle(key string) *persistConn {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	conns := t.idle[key]
+	for len(conns) > 0 {
+		pc := conns[len(conns)-1]
+		conns = conns[:len(conns)-1]
+		t.idle[key] = conns
+
+		if time.Since(pc.idleSince) > t.idleTimeout() {
+			pc.conn.Close()
+			continue
+		}
+		return pc
+	}
+
+	return nil
+}
+
+// putIdle returns pc to the pool, or closes it when the pool for its host
+// is full.
+func (t *Transport) putIdle(pc *persistConn) {
+	_ = pc.conn.SetDeadline(time.Time{})
+	pc.idleSince = time.Now()
+	_ = pc.conn.SetReadDeadline(pc.idleSince.Add(t.idleTimeout()))
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.idle[pc.key]) >= t.maxIdlePerHost() {
+		pc.conn.Close()
+		return
+	}
+
+	if t.idle == nil {
+		t.idle = make(map[string][]*persistConn)
+	}
+	t.idle[pc.key] = append(t.idle[pc.key], pc)
+
+	pc.idleRead = make(chan error, 1)
+	go t.watchIdle(pc)
+}
+
+// watchIdle blocks reading from an idle connection. A server closing it,
+// sending bytes nobody asked for, or the IdleTimeout deadline set by putIdle
+// ends the read, and the connection is dropped from the pool right away.
+// healthy ends the read with an earlier deadline when the connection is
+// taken for a request, which leaves it open.
+func (t *Transport) watchIdle(pc *persistConn) {
+	_, err := pc.conn.Read(make([]byte, 1))
+	expired := time.Since(pc.idleSince) >= t.idleTimeout()
+	pc.idleRead <- err
+	if errors.Is(err, os.ErrDeadlineExceeded) && !expired {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	conns := t.idle[pc.key]
+	for i, idle := range conns {
+		if idle == pc {
+			t.idle[pc.key] = slices.Delete(conns, i, i+1)
+			pc.conn.Close()
+			return
+		}
+	}
+}
+
+func (t *Transport) maxIdlePerHost() int {
+	if t.MaxIdlePerHost == 0 {
+		return defaultMaxIdlePerHost
+	}
+	return max(t.MaxIdlePerHost, 0)
+}
+
+func (t *Transport) idleTimeout() time.Duration {
+	if t.IdleTimeout == 0 {
+		return defaultIdleTimeout
+	}
+	return t.IdleTimeout
+}
+
+// healthy stops watching a connection taken from the pool. Only a watch
+// that was still waiting when interrupted means the connection is usable.
+func (pc *persistConn) healthy() bool {
+	_ = pc.conn.SetReadDeadline(time.Unix(1, 0))
+	err := <-pc.idleRead
+	_ = pc.conn.SetReadDeadline(time.Time{})
+
+	return errors.Is(err, os.ErrDeadlineExceeded) && pc.reader.Buffered() == 0
+}
+
+// dial connects to u within DialTimeout and before deadline, whichever
+// comes first.
+func (t *Transport) dial(u *url.URL, deadline time.Time) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: t.DialTimeout, Deadline: deadline}
+
+	switch u.Scheme {
+	case "http":
+		return dialer.Dial("tcp", hostPort(u))
+	case "https":
+		config := &tls.Config{}
+		if t.TLSConfig != nil {
+			config = t.TLSConfig.Clone()
+		}
+		if config.ServerName == "" {
+			config.ServerName = u.Hostname()
+		}
+		if len(config.NextProtos) == 0 {
+			config.NextProtos = []string{"http/1.1"}
+		}
+		return tls.DialWithDialer(dialer, "tcp", hostPort(u), config)
+	default:
+		return nil, fmt.Errorf("dial: %w: %q", ErrUnsupportedScheme, u.Scheme)
+	}
+}
+
+// hostPort returns the address to dial for u, adding the scheme's default
+// port when u has none.
+func hostPort(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+
+	return net.JoinHostPort(u.Hostname(), port)
+}
@@ -0,0 +1,164 @@
+package client
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/davidw1457/httpfromtcp/internal/request"
+	"github.com/davidw1457/httpfromtcp/internal/response"
+	"github.com/davidw1457/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransportReuse(t *testing.T) {
+	transport := &Transport{}
+	c := &Client{Transport: transport}
+	baseURL := startServer(t, echoRemoteAddr)
+	otherURL := startServer(t, echoRemoteAddr)
+
+	// Test: Sequential requests share one connection
+	first := do(t, c, baseURL+"/")
+	second := do(t, c, baseURL+"/")
+	assert.Equal(t, first, second)
+	assert.Equal(t, PoolStats{Hits: 1, Misses: 1, Idle: 1}, transport.Stats())
+
+	// Test: Pools are keyed by host and port
+	do(t, c, otherURL+"/")
+	assert.Equal(t, PoolStats{Hits: 1, Misses: 2, Idle: 2}, transport.Stats())
+
+	// Test: Request asking to close is not pooled
+	req, err := NewRequest("GET", baseURL+"/", nil)
+	require.NoError(t, err)
+	req.Headers.Set("Connection", "close")
+	resp, err := c.Do(req)
+	require.NoError(t, err)
+	readBody(t, resp)
+	assert.Equal(t, 1, transport.Stats().Idle)
+
+	// Test: Body closed unread is drained and the connection pooled
+	resp, err = c.Get(otherURL + "/")
+	require.NoError(t, err)
+	require.NoError(t, resp.BodyReader.Close())
+	assert.Equal(t, 1, transport.Stats().Idle)
+
+	transport.CloseIdleConnections()
+	assert.Equal(t, 0, transport.Stats().Idle)
+}
+
+func TestTransportPoolLimits(t *testing.T) {
+	baseURL := startServer(t, echoRemoteAddr)
+
+	// Test: MaxIdlePerHost caps pooled connections
+	transport := &Transport{MaxIdlePerHost: 1}
+	c := &Client{Transport: transport}
+	var responses []*response.Response
+	for range 3 {
+		resp, err := c.Get(baseURL + "/")
+		require.NoError(t, err)
+		responses = append(responses, resp)
+	}
+	for _, resp := range responses {
+		readBody(t, resp)
+	}
+	assert.Equal(t, PoolStats{Hits: 0, Misses: 3, Idle: 1}, transport.Stats())
+
+	// Test: Connections idle past IdleTimeout are not reused
+	transport = &Transport{IdleTimeout: 20 * time.Millisecond}
+	c = &Client{Transport: transport}
+	do(t, c, baseURL+"/")
+	time.Sleep(50 * time.Millisecond)
+	do(t, c, baseURL+"/")
+	assert.Equal(t, int64(0), transport.Stats().Hits)
+	assert.Equal(t, int64(2), transport.Stats().Misses)
+
+	// Test: Connections idle past IdleTimeout are closed without a request
+	assert.Eventually(t, func() bool {
+		return transport.Stats().Idle == 0
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestTransportHealthCheck(t *testing.T) {
+	s := &server.Server{
+		Addr:        "127.0.0.1:0",
+		Handler:     echoRemoteAddr,
+		IdleTimeout: 20 * time.Millisecond,
+	}
+	require.NoError(t, s.Start())
+	t.Cleanup(func() { _ = s.Close() })
+	baseURL := "http://" + s.ListenAddr().String()
+
+	transport := &Transport{}
+	c := &Client{Transport: transport}
+
+	// Test: Connection closed by the server while idle leaves the pool
+	do(t, c, baseURL+"/")
+	assert.Eventually(t, func() bool {
+		return transport.Stats().Idle == 0
+	}, time.Second, 5*time.Millisecond)
+	do(t, c, baseURL+"/")
+	assert.Equal(t, int64(0), transport.Stats().Hits)
+	assert.Equal(t, int64(2), transport.Stats().Misses)
+}
+
+// echoRemoteAddr answers with the client's address, which identifies the
+// connection the request arrived on.
+func echoRemoteAddr(w *response.Writer, req *request.Request) {
+	body := []byte(req.RemoteAddr)
+	_ = w.WriteStatusLine(response.OK)
+	_ = w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	_, _ = w.WriteBody(body)
+}
+
+func do(t *testing.T, c *Client, url string) string {
+	t.Helper()
+	resp, err := c.Get(url)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.BodyReader)
+	require.NoError(t, err)
+	require.NoError(t, resp.BodyReader.Close())
+	return string(body)
+}
+
+func TestTransportRetry(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			// Answer one request, then drop the connection as soon as the
+			// next one arrives, like a server closing it at the same time.
+			go func() {
+				defer conn.Close()
+				reader := request.NewReader(conn)
+				_, err := reader.ReadRequest()
+				if err != nil {
+					return
+				}
+				_, _ = conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"))
+				_ = reader.Fill()
+			}()
+		}
+	}()
+	baseURL := "http://" + ln.Addr().String() + "/"
+
+	transport := &Transport{}
+	c := &Client{Transport: transport}
+
+	// Test: Idempotent request on a stale connection is sent again
+	assert.Equal(t, "ok", do(t, c, baseURL))
+	assert.Equal(t, "ok", do(t, c, baseURL))
+	assert.Equal(t, PoolStats{Hits: 1, Misses: 2, Idle: 1}, transport.Stats())
+
+	// Test: POST on a stale connection is not
+	req, err := NewRequest("POST", baseURL, nil)
+	require.NoError(t, err)
+	_, err = c.Do(req)
+	assert.Error(t, err)
+}
@@ -2,15 +2,129 @@ package headers
 
 import (
 	"bytes"
+	"cmp"
+	"errors"
 	"fmt"
-	"regexp"
+	"iter"
+	"maps"
+	"slices"
 	"strings"
+	"sync/atomic"
 )
 
-type Headers map[string]string
+// Headers holds header fields keyed by lowercase name. Each field keeps the
+// name as it was first given, for the wire, and every value in the order it
+// was added; fields are listed in the order they were first added.
+type Headers map[string]*field
 
+type field struct {
+	name   string
+	values []string
+	seq    uint64
+}
+
+// fieldSeq orders fields across all Headers, so a field copied from one
+// Headers into another keeps its place relative to the others.
+var fieldSeq atomic.Uint64
+
+var (
+	ErrMalformedHeader          = errors.New("malformed header field")
+	ErrObsFold                  = errors.New("obsolete line folding")
+	ErrConflictingContentLength = errors.New("conflicting content-length values")
+)
+
+// InvalidFieldError reports a header field that cannot be written without
+// corrupting the message: a name that is not a token, or a value holding
+// CR, LF or NUL, which would let the value start a new field or message.
+// It matches ErrMalformedHeader with errors.Is.
+type InvalidFieldError struct {
+	Name  string
+	Value string
+}
+
+func (e *InvalidFieldError) Error() string {
+	if !IsToken(e.Name) {
+		return fmt.Sprintf("invalid header field name %q", e.Name)
+	}
+	return fmt.Sprintf("invalid value for header field %s: %q", e.Name, e.Value)
+}
+
+func (e *InvalidFieldError) Unwrap() error {
+	return ErrMalformedHeader
+}
+
+// IsToken reports whether s is a token (RFC 9110 section 5.6.2), the
+// grammar of field names.
+func IsToken(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// IsValidValue reports whether s can be written as a field value: it must
+// not contain CR, LF or NUL.
+func IsValidValue(s string) bool {
+	return !strings.ContainsAny(s, "\r\n\x00")
+}
+
+// Validate checks every field with IsToken and IsValidValue, returning an
+// *InvalidFieldError for the first one that fails.
+func (h Headers) Validate() error {
+	for name, values := range h.All() {
+		if !IsToken(name) {
+			return &InvalidFieldError{Name: name}
+		}
+		for _, v := range values {
+			if !IsValidValue(v) {
+				return &InvalidFieldError{Name: name, Value: v}
+			}
+		}
+	}
+	return nil
+}
+
+// ObsFoldPolicy is what parsing does with obsolete line folding: a field
+// line continued on the next line by starting it with SP or HTAB (RFC 9112
+// section 5.2).
+type ObsFoldPolicy int
+
+const (
+	// ObsFoldReject fails the parse, as a server should.
+	ObsFoldReject ObsFoldPolicy = iota
+	// ObsFoldReplace joins the lines with a single SP, as a client or
+	// proxy must.
+	ObsFoldReplace
+)
+
+// Parse parses one field line from data, rejecting obsolete line folding.
+// It returns done once it reaches the empty line ending the fields.
 func (h Headers) Parse(data []byte) (n int, done bool, err error) {
-	kv, n, err := parseHeaderLine(data)
+	return h.ParseFolded(data, ObsFoldReject)
+}
+
+// ParseFolded is Parse with a choice of what to do with obsolete line
+// folding. A field line is only parsed once the first byte of the line
+// after it has arrived, since that line may continue it.
+//
+// Repeated Content-Length fields, or one listing several values, are
+// collapsed into one value when they agree and rejected with
+// ErrConflictingContentLength when they do not (RFC 9110 section 8.6).
+func (h Headers) ParseFolded(
+	data []byte,
+	obsFold ObsFoldPolicy,
+) (n int, done bool, err error) {
+	kv, n, err := parseHeaderLine(data, obsFold)
 	if err != nil {
 		return 0, false, fmt.Errorf("headers.Parse: %w", err)
 	}
@@ -20,19 +134,45 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 	if kv == nil && n == 2 {
 		return n, true, nil
 	}
-	if old, ok := h[kv[0]]; ok {
-		kv[1] = fmt.Sprintf("%s, %s", old, kv[1])
+
+	if strings.EqualFold(kv[0], "Content-Length") {
+		length, err := h.contentLength(kv[1])
+		if err != nil {
+			return 0, false, fmt.Errorf("headers.Parse: %w", err)
+		}
+		h.Set(kv[0], length)
+		return n, false, nil
 	}
 
-	h[kv[0]] = kv[1]
+	h.Add(kv[0], kv[1])
 	return n, false, nil
 }
 
+// contentLength checks that a Content-Length value agrees with itself and
+// with any value already parsed, and returns the single length.
+func (h Headers) contentLength(value string) (string, error) {
+	lengths := strings.Split(value, ",")
+	lengths = append(lengths, h.Values("Content-Length")...)
+
+	first := strings.Trim(lengths[0], " \t")
+	for _, length := range lengths[1:] {
+		if strings.Trim(length, " \t") != first {
+			return "", fmt.Errorf(
+				"%w: %w: %s",
+				ErrMalformedHeader,
+				ErrConflictingContentLength,
+				value,
+			)
+		}
+	}
+	return first, nil
+}
+
 func NewHeaders() Headers {
-	return Headers(make(map[string]string))
+	return Headers(make(map[string]*field))
 }
 
-func parseHeaderLine(input []byte) ([]string, int, error) {
+func parseHeaderLine(input []byte, obsFold ObsFoldPolicy) ([]string, int, error) {
 	idx := bytes.Index(input, []byte("\r\n"))
 	if idx == -1 {
 		return nil, 0, nil
@@ -42,14 +182,45 @@ func parseHeaderLine(input []byte) ([]string, int, error) {
 	}
 
 	line := string(input[:idx])
+	n := idx + 2
+	for {
+		if n == len(input) {
+			return nil, 0, nil
+		}
+		if input[n] != ' ' && input[n] != '\t' {
+			break
+		}
+		if obsFold == ObsFoldReject {
+			return nil, 0, fmt.Errorf(
+				"parseHeaderLine: %w: %w: %s",
+				ErrMalformedHeader,
+				ErrObsFold,
+				line,
+			)
+		}
+
+		next := bytes.Index(input[n:], []byte("\r\n"))
+		if next == -1 {
+			return nil, 0, nil
+		}
+		// obs-fold is OWS CRLF RWS; all of it becomes one SP.
+		line = strings.TrimRight(line, " \t") + " " +
+			strings.TrimLeft(string(input[n:n+next]), " \t")
+		n += next + 2
+	}
+
 	kv, err := headerLineFromString(line)
 	if err != nil {
-		return nil, idx + 2, fmt.Errorf("parseHeaderLine: %w", err)
+		return nil, n, fmt.Errorf("parseHeaderLine: %w", err)
 	}
 
-	return kv, idx + 2, nil
+	return kv, n, nil
 }
 
+// headerLineFromString splits a field line into its name and value. No
+// whitespace is allowed before the name or between the name and the colon
+// (RFC 9112 section 5.1); the value is trimmed of SP and HTAB and may not
+// hold CR, LF or NUL.
 func headerLineFromString(line string) ([]string, error) {
 	if len(line) == 0 {
 		return nil, nil
@@ -58,51 +229,141 @@ func headerLineFromString(line string) ([]string, error) {
 	idx := strings.Index(line, ":")
 	if idx < 1 {
 		return nil, fmt.Errorf(
-			"headerLineFromString: improper header line: %s",
+			"headerLineFromString: %w: improper header line: %s",
+			ErrMalformedHeader,
 			line,
 		)
 	}
 
-	key := strings.ToLower(strings.TrimSpace(line[:idx]))
-	isValid, err := regexp.MatchString("^[\\w!#$%&'*+-.^_`|~]+$", key)
-	if err != nil {
+	key := line[:idx]
+	if !IsToken(key) {
 		return nil, fmt.Errorf(
-			"headerLineFromString: %w",
-			err,
+			"headerLineFromString: %w: improper key value: %s",
+			ErrMalformedHeader,
+			line,
 		)
 	}
-	if strings.TrimSpace(line[idx-1:idx]) == "" || key == "" || !isValid {
+
+	value := strings.Trim(line[idx+1:], " \t")
+	if !IsValidValue(value) {
 		return nil, fmt.Errorf(
-			"headerLineFromString: improper key value: %s",
+			"headerLineFromString: %w: improper value: %q",
+			ErrMalformedHeader,
 			line,
 		)
 	}
 
-	value := strings.TrimSpace(line[idx+1:])
-
 	return []string{key, value}, nil
 }
 
+// Get returns the values of a field joined with ", ", the way repeated
+// fields are combined (RFC 9110 section 5.3). Use Values for fields such as
+// Set-Cookie that cannot be combined.
 func (h Headers) Get(key string) (string, bool) {
-	key = strings.ToLower(key)
-	val, ok := h[key]
-	return val, ok
+	f, ok := h[strings.ToLower(key)]
+	if !ok {
+		return "", false
+	}
+	return strings.Join(f.values, ", "), true
+}
+
+// First returns the first value of a field.
+func (h Headers) First(key string) (string, bool) {
+	f, ok := h[strings.ToLower(key)]
+	if !ok {
+		return "", false
+	}
+	return f.values[0], true
+}
+
+// Values returns a copy of every value of a field, in the order added.
+func (h Headers) Values(key string) []string {
+	f, ok := h[strings.ToLower(key)]
+	if !ok {
+		return nil
+	}
+	return slices.Clone(f.values)
 }
 
+// Set replaces the values of a field. An existing field keeps its name and
+// position.
 func (h Headers) Set(key string, val string) {
-	key = strings.ToLower(key)
-	h[key] = val
+	f, ok := h[strings.ToLower(key)]
+	if !ok {
+		h.Add(key, val)
+		return
+	}
+	f.values = []string{val}
 }
 
+// Add appends a value to a field, adding the field after the others if it
+// is new.
 func (h Headers) Add(key string, val string) {
-	key = strings.ToLower(key)
-	if v, ok := h[key]; ok {
-		val = fmt.Sprintf("%s, %s", v, val)
+	lower := strings.ToLower(key)
+	f, ok := h[lower]
+	if !ok {
+		h[lower] = &field{
+			name:   key,
+			values: []string{val},
+			seq:    fieldSeq.Add(1),
+		}
+		return
 	}
-	h[key] = val
+	f.values = append(f.values, val)
 }
 
 func (h Headers) Delete(key string) {
-	key = strings.ToLower(key)
-	delete(h, key)
+	delete(h, strings.ToLower(key))
+}
+
+// All iterates over the fields in order, yielding each field's name as
+// first given and its values.
+func (h Headers) All() iter.Seq2[string, []string] {
+	return func(yield func(string, []string) bool) {
+		fields := slices.Collect(maps.Values(h))
+		slices.SortFunc(fields, func(a, b *field) int {
+			return cmp.Compare(a.seq, b.seq)
+		})
+
+		for _, f := range fields {
+			if !yield(f.name, f.values) {
+				return
+			}
+		}
+	}
+}
+
+// Clone returns a copy of h that shares nothing with it.
+func (h Headers) Clone() Headers {
+	clone := make(Headers, len(h))
+	for k, f := range h {
+		clone[k] = &field{
+			name:   f.name,
+			values: slices.Clone(f.values),
+			seq:    f.seq,
+		}
+	}
+	return clone
+}
+
+// Merge copies the fields of other into h, replacing any field h already
+// has with the same name. Replaced fields keep their position in h.
+func (h Headers) Merge(other Headers) {
+	for name, values := range other.All() {
+		f, ok := h[strings.ToLower(name)]
+		if !ok {
+			f = &field{name: name, seq: fieldSeq.Add(1)}
+			h[strings.ToLower(name)] = f
+		}
+		f.values = slices.Clone(values)
+	}
+}
+
+func (h Headers) ContainsToken(key string, token string) bool {
+	for _, v := range h.List(key) {
+		if strings.EqualFold(v, token) {
+			return true
+		}
+	}
+	return false
 }
@@ -1,6 +1,7 @@
 package headers
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -14,29 +15,37 @@ func TestHeadersParse(t *testing.T) {
 	n, done, err := headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
+	assert.Equal(t, []string{"localhost:42069"}, headers.Values("host"))
 	assert.Equal(t, 23, n)
 	assert.False(t, done)
 
-	// Test: Valid single header with extra whitespace
+	// Test: Whitespace before the field name
 	headers = NewHeaders()
 	data = []byte("       Host: localhost:42069                           " +
 		"\r\n\r\n")
 	n, done, err = headers.Parse(data)
+	require.ErrorIs(t, err, ErrMalformedHeader)
+	assert.Equal(t, 0, n)
+	assert.False(t, done)
+
+	// Test: Valid single header with whitespace around the value
+	headers = NewHeaders()
+	data = []byte("Host:    localhost:42069        \r\n\r\n")
+	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
-	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
-	assert.Equal(t, 57, n)
+	assert.Equal(t, []string{"localhost:42069"}, headers.Values("host"))
+	assert.Equal(t, 34, n)
 	assert.False(t, done)
 
 	// Test: Valid 2 headers with existing headers
-	headers = map[string]string{"host": "localhost:42069"}
+	headers = NewHeaders()
+	headers.Set("Host", "localhost:42069")
 	data = []byte("User-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n")
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
-	assert.Equal(t, "curl/7.81.0", headers["user-agent"])
+	assert.Equal(t, []string{"localhost:42069"}, headers.Values("host"))
+	assert.Equal(t, []string{"curl/7.81.0"}, headers.Values("user-agent"))
 	assert.Equal(t, 25, n)
 	assert.False(t, done)
 
@@ -58,6 +67,42 @@ func TestHeadersParse(t *testing.T) {
 	assert.Equal(t, 0, n)
 	assert.False(t, done)
 
+	// Test: Tab before the colon
+	headers = NewHeaders()
+	data = []byte("Host\t: localhost:42069\r\n\r\n")
+	n, done, err = headers.Parse(data)
+	require.ErrorIs(t, err, ErrMalformedHeader)
+	assert.Equal(t, 0, n)
+	assert.False(t, done)
+
+	// Test: Space inside the field name
+	headers = NewHeaders()
+	data = []byte("Ho st: localhost:42069\r\n\r\n")
+	_, _, err = headers.Parse(data)
+	require.ErrorIs(t, err, ErrMalformedHeader)
+
+	// Test: Bare CR in the value
+	headers = NewHeaders()
+	data = []byte("Host: local\rhost\r\n\r\n")
+	_, _, err = headers.Parse(data)
+	require.ErrorIs(t, err, ErrMalformedHeader)
+
+	// Test: Line is not parsed until the next line starts
+	headers = NewHeaders()
+	data = []byte("Host: localhost:42069\r\n")
+	n, done, err = headers.Parse(data)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	assert.False(t, done)
+
+	// Test: Comma is not a token character
+	headers = NewHeaders()
+	data = []byte("Ho,st: localhost:42069\r\n\r\n")
+	n, done, err = headers.Parse(data)
+	require.Error(t, err)
+	assert.Equal(t, 0, n)
+	assert.False(t, done)
+
 	// Test: Invalid character header
 	headers = NewHeaders()
 	data = []byte("H©st: localhost:42069\r\n\r\n")
@@ -66,3 +111,175 @@ func TestHeadersParse(t *testing.T) {
 	assert.Equal(t, 0, n)
 	assert.False(t, done)
 }
+
+func TestHeadersOrderAndValues(t *testing.T) {
+	headers := NewHeaders()
+	data := []byte("Host: localhost:42069\r\n" +
+		"Set-Cookie: a=1; Path=/\r\n" +
+		"X-Custom: first\r\n" +
+		"set-cookie: b=2, expires=never\r\n" +
+		"\r\n")
+	n := 0
+	for {
+		read, done, err := headers.Parse(data[n:])
+		require.NoError(t, err)
+		n += read
+		if done {
+			break
+		}
+	}
+
+	// Test: Repeated fields keep each value separately
+	assert.Equal(t,
+		[]string{"a=1; Path=/", "b=2, expires=never"},
+		headers.Values("Set-Cookie"),
+	)
+	first, ok := headers.First("SET-COOKIE")
+	assert.True(t, ok)
+	assert.Equal(t, "a=1; Path=/", first)
+	joined, ok := headers.Get("set-cookie")
+	assert.True(t, ok)
+	assert.Equal(t, "a=1; Path=/, b=2, expires=never", joined)
+
+	// Test: Fields are listed in order with their original casing
+	var names []string
+	for name := range headers.All() {
+		names = append(names, name)
+	}
+	assert.Equal(t, []string{"Host", "Set-Cookie", "X-Custom"}, names)
+
+	// Test: Set keeps the field's position, Add appends new fields
+	headers.Set("host", "example.com")
+	headers.Add("X-Later", "1")
+	names = nil
+	for name, values := range headers.All() {
+		names = append(names, name+"="+values[0])
+	}
+	assert.Equal(t, []string{
+		"Host=example.com",
+		"Set-Cookie=a=1; Path=/",
+		"X-Custom=first",
+		"X-Later=1",
+	}, names)
+
+	// Test: Delete removes every value
+	headers.Delete("SET-COOKIE")
+	assert.Nil(t, headers.Values("Set-Cookie"))
+	_, ok = headers.First("Set-Cookie")
+	assert.False(t, ok)
+}
+
+func TestHeadersCloneAndMerge(t *testing.T) {
+	base := NewHeaders()
+	base.Set("Content-Type", "text/plain")
+	base.Add("Vary", "Accept")
+
+	// Test: Clone shares nothing with the original
+	clone := base.Clone()
+	clone.Add("Vary", "Accept-Language")
+	assert.Equal(t, []string{"Accept"}, base.Values("Vary"))
+	assert.Equal(t, []string{"Accept", "Accept-Language"}, clone.Values("Vary"))
+
+	// Test: Merge replaces existing fields in place and appends new ones
+	other := NewHeaders()
+	other.Add("X-New", "1")
+	other.Add("content-type", "application/json")
+	base.Merge(other)
+	var names []string
+	for name, values := range base.All() {
+		names = append(names, name+"="+values[0])
+	}
+	assert.Equal(t, []string{
+		"Content-Type=application/json",
+		"Vary=Accept",
+		"X-New=1",
+	}, names)
+
+	// Test: ContainsToken looks at every value
+	assert.True(t, clone.ContainsToken("vary", "accept-language"))
+	assert.False(t, base.ContainsToken("vary", "accept-language"))
+}
+
+func TestValidate(t *testing.T) {
+	// Test: Token grammar
+	assert.True(t, IsToken("X-Custom_Header.1"))
+	assert.True(t, IsToken("!#$%&'*+-.^_`|~"))
+	assert.False(t, IsToken(""))
+	assert.False(t, IsToken("Bad Name"))
+	assert.False(t, IsToken("Bad,Name"))
+	assert.False(t, IsToken("Bad:Name"))
+	assert.False(t, IsToken("Bäd"))
+
+	// Test: Values may not contain CR, LF or NUL
+	assert.True(t, IsValidValue("text/html; charset=utf-8\t"))
+	assert.False(t, IsValidValue("a\r\nb"))
+	assert.False(t, IsValidValue("a\x00b"))
+
+	// Test: Validate reports the offending field
+	headers := NewHeaders()
+	headers.Set("Content-Type", "text/plain")
+	require.NoError(t, headers.Validate())
+	headers.Set("Location", "/\r\nSet-Cookie: a=1")
+	err := headers.Validate()
+	var fieldErr *InvalidFieldError
+	require.ErrorAs(t, err, &fieldErr)
+	assert.Equal(t, "Location", fieldErr.Name)
+	assert.ErrorIs(t, err, ErrMalformedHeader)
+}
+
+func TestParseObsFold(t *testing.T) {
+	data := []byte("X-Folded: first \r\n  second\r\n\tthird\r\nHost: x\r\n\r\n")
+
+	// Test: Folding is rejected
+	headers := NewHeaders()
+	n, _, err := headers.ParseFolded(data, ObsFoldReject)
+	require.ErrorIs(t, err, ErrObsFold)
+	assert.Equal(t, 0, n)
+
+	// Test: Each fold is replaced with a single SP
+	headers = NewHeaders()
+	n, done, err := headers.ParseFolded(data, ObsFoldReplace)
+	require.NoError(t, err)
+	assert.False(t, done)
+	assert.Equal(t, 36, n)
+	assert.Equal(t, []string{"first second third"}, headers.Values("X-Folded"))
+
+	// Test: Folded line waits for the line after the continuation
+	headers = NewHeaders()
+	n, _, err = headers.ParseFolded(data[:27], ObsFoldReplace)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestParseContentLength(t *testing.T) {
+	parse := func(lines ...string) (Headers, error) {
+		headers := NewHeaders()
+		data := []byte(strings.Join(lines, "\r\n") + "\r\n\r\n")
+		for {
+			n, done, err := headers.Parse(data)
+			if err != nil || done {
+				return headers, err
+			}
+			data = data[n:]
+		}
+	}
+
+	// Test: Identical repeated values collapse into one
+	headers, err := parse("Content-Length: 42", "content-length: 42")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"42"}, headers.Values("Content-Length"))
+
+	// Test: Identical values in one list collapse into one
+	headers, err = parse("Content-Length: 42, 42,42")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"42"}, headers.Values("Content-Length"))
+
+	// Test: Conflicting values are rejected
+	_, err = parse("Content-Length: 42", "Content-Length: 43")
+	require.ErrorIs(t, err, ErrConflictingContentLength)
+	require.ErrorIs(t, err, ErrMalformedHeader)
+	_, err = parse("Content-Length: 42, 43")
+	require.ErrorIs(t, err, ErrConflictingContentLength)
+	_, err = parse("Content-Length: 42,")
+	require.ErrorIs(t, err, ErrConflictingContentLength)
+}
@@ -0,0 +1,764 @@
+package headers
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidStructuredField is returned when a Structured Field Value (RFC
+// 8941) cannot be parsed, or a value cannot be serialized as one.
+var ErrInvalidStructuredField = errors.New("invalid structured field value")
+
+// Token is a structured field token. Parsing returns tokens as Token and
+// strings as string so the two can be told apart.
+type Token string
+
+// Item is a bare item with parameters. Value holds one of int64 (Integer),
+// float64 (Decimal), string (String), Token, []byte (Byte Sequence) or
+// bool (Boolean). Serializing also accepts int for an Integer.
+type Item struct {
+	Value  any
+	Params Params
+}
+
+// InnerList is a parenthesized list of items with parameters of its own.
+type InnerList struct {
+	Items  []Item
+	Params Params
+}
+
+// Member is a member of a List or Dictionary: an Item or an InnerList.
+type Member interface {
+	serializeMember(b *strings.Builder) error
+}
+
+// List is a structured field List.
+type List []Member
+
+// Dictionary is a structured field Dictionary, in order.
+type Dictionary []DictionaryMember
+
+type DictionaryMember struct {
+	Key   string
+	Value Member
+}
+
+// Params are the parameters of an Item or InnerList, in order.
+type Params []Param
+
+type Param struct {
+	Key   string
+	Value any
+}
+
+// StructuredField is a top-level structured field value: an Item, a List
+// or a Dictionary.
+type StructuredField interface {
+	serialize(b *strings.Builder) error
+}
+
+// Get returns the value of the member named key.
+func (d Dictionary) Get(key string) (Member, bool) {
+	for _, m := range d {
+		if m.Key == key {
+			return m.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Get returns the value of the parameter named key.
+func (p Params) Get(key string) (any, bool) {
+	for _, param := range p {
+		if param.Key == key {
+			return param.Value, true
+		}
+	}
+	return nil, false
+}
+
+// StructuredItem parses a field defined as a structured Item. ok is false
+// if the field is missing.
+func (h Headers) StructuredItem(key string) (item Item, ok bool, err error) {
+	val, ok := h.Get(key)
+	if !ok {
+		return Item{}, false, nil
+	}
+
+	item, err = ParseItem(val)
+	if err != nil {
+		return Item{}, true, fmt.Errorf("headers.StructuredItem: %s: %w", key, err)
+	}
+	return item, true, nil
+}
+
+// StructuredList parses a field defined as a structured List. Repeated
+// field lines are combined into one List. ok is false if the field is
+// missing.
+func (h Headers) StructuredList(key string) (list List, ok bool, err error) {
+	val, ok := h.Get(key)
+	if !ok {
+		return nil, false, nil
+	}
+
+	list, err = ParseList(val)
+	if err != nil {
+		return nil, true, fmt.Errorf("headers.StructuredList: %s: %w", key, err)
+	}
+	return list, true, nil
+}
+
+// StructuredDictionary parses a field defined as a structured Dictionary.
+// Repeated field lines are combined into one Dictionary. ok is false if
+// the field is missing.
+func (h Headers) StructuredDictionary(
+	key string,
+) (dict Dictionary, ok bool, err error) {
+	val, ok := h.Get(key)
+	if !ok {
+		return nil, false, nil
+	}
+
+	dict, err = ParseDictionary(val)
+	if err != nil {
+		return nil, true, fmt.Errorf(
+			"headers.StructuredDictionary: %s: %w",
+			key,
+			err,
+		)
+	}
+	return dict, true, nil
+}
+
+// SetStructured serializes sf and sets the field to it. An empty List or
+// Dictionary removes the field, since it has no serialization.
+func (h Headers) SetStructured(key string, sf StructuredField) error {
+	val, err := Serialize(sf)
+	if err != nil {
+		return fmt.Errorf("headers.SetStructured: %s: %w", key, err)
+	}
+
+	if val == "" {
+		h.Delete(key)
+		return nil
+	}
+	h.Set(key, val)
+	return nil
+}
+
+// Serialize returns the serialization of an Item, List or Dictionary
+// (RFC 8941 section 4.1).
+func Serialize(sf StructuredField) (string, error) {
+	var b strings.Builder
+	err := sf.serialize(&b)
+	if err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// ParseItem parses a structured Item (RFC 8941 section 4.2).
+func ParseItem(s string) (Item, error) {
+	p := &sfParser{input: s}
+	p.discardSP()
+	item, err := p.parseItem()
+	if err != nil {
+		return Item{}, err
+	}
+	return item, p.finish()
+}
+
+// ParseList parses a structured List (RFC 8941 section 4.2).
+func ParseList(s string) (List, error) {
+	p := &sfParser{input: s}
+	p.discardSP()
+	list, err := p.parseList()
+	if err != nil {
+		return nil, err
+	}
+	return list, p.finish()
+}
+
+// ParseDictionary parses a structured Dictionary (RFC 8941 section 4.2).
+func ParseDictionary(s string) (Dictionary, error) {
+	p := &sfParser{input: s}
+	p.discardSP()
+	dict, err := p.parseDictionary()
+	if err != nil {
+		return nil, err
+	}
+	return dict, p.finish()
+}
+
+// sfParser consumes input from the front as each part is parsed.
+type sfParser struct {
+	input string
+}
+
+func (p *sfParser) fail(format string, args ...any) error {
+	return fmt.Errorf(
+		"%w: %s at %q",
+		ErrInvalidStructuredField,
+		fmt.Sprintf(format, args...),
+		p.input,
+	)
+}
+
+func (p *sfParser) finish() error {
+	p.discardSP()
+	if p.input != "" {
+		return p.fail("unexpected input")
+	}
+	return nil
+}
+
+func (p *sfParser) peek() (byte, bool) {
+	if p.input == "" {
+		return 0, false
+	}
+	return p.input[0], true
+}
+
+func (p *sfParser) consume(c byte) bool {
+	if p.input == "" || p.input[0] != c {
+		return false
+	}
+	p.input = p.input[1:]
+	return true
+}
+
+func (p *sfParser) discardSP() {
+	p.input = strings.TrimLeft(p.input, " ")
+}
+
+func (p *sfParser) discardOWS() {
+	p.input = strings.TrimLeft(p.input, " \t")
+}
+
+// nextMember moves past the comma between members. done is true at the end
+// of the input.
+func (p *sfParser) nextMember() (done bool, err error) {
+	p.discardOWS()
+	if p.input == "" {
+		return true, nil
+	}
+	if !p.consume(',') {
+		return false, p.fail("expected comma")
+	}
+	p.discardOWS()
+	if p.input == "" {
+		return false, p.fail("trailing comma")
+	}
+	return false, nil
+}
+
+func (p *sfParser) parseList() (List, error) {
+	list := List{}
+	for p.input != "" {
+		member, err := p.parseItemOrInnerList()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, member)
+
+		done, err := p.nextMember()
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			break
+		}
+	}
+	return list, nil
+}
+
+func (p *sfParser) parseDictionary() (Dictionary, error) {
+	dict := Dictionary{}
+	for p.input != "" {
+		key, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+
+		var member Member
+		if p.consume('=') {
+			member, err = p.parseItemOrInnerList()
+		} else {
+			var params Params
+			params, err = p.parseParameters()
+			member = Item{Value: true, Params: params}
+		}
+		if err != nil {
+			return nil, err
+		}
+		dict = dict.set(key, member)
+
+		done, err := p.nextMember()
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			break
+		}
+	}
+	return dict, nil
+}
+
+// set overwrites an existing member in place or appends a new one.
+func (d Dictionary) set(key string, member Member) Dictionary {
+	for i := range d {
+		if d[i].Key == key {
+			d[i].Value = member
+			return d
+		}
+	}
+	return append(d, DictionaryMember{Key: key, Value: member})
+}
+
+func (p *sfParser) parseItemOrInnerList() (Member, error) {
+	if c, _ := p.peek(); c == '(' {
+		return p.parseInnerList()
+	}
+	return p.parseItem()
+}
+
+func (p *sfParser) parseInnerList() (InnerList, error) {
+	p.consume('(')
+	inner := InnerList{Items: []Item{}}
+	for p.input != "" {
+		p.discardSP()
+		if p.consume(')') {
+			params, err := p.parseParameters()
+			if err != nil {
+				return InnerList{}, err
+			}
+			inner.Params = params
+			return inner, nil
+		}
+
+		item, err := p.parseItem()
+		if err != nil {
+			return InnerList{}, err
+		}
+		inner.Items = append(inner.Items, item)
+
+		if c, ok := p.peek(); ok && c != ' ' && c != ')' {
+			return InnerList{}, p.fail("expected space or ) in inner list")
+		}
+	}
+	return InnerList{}, p.fail("unterminated inner list")
+}
+
+func (p *sfParser) parseItem() (Item, error) {
+	value, err := p.parseBareItem()
+	if err != nil {
+		return Item{}, err
+	}
+
+	params, err := p.parseParameters()
+	if err != nil {
+		return Item{}, err
+	}
+	return Item{Value: value, Params: params}, nil
+}
+
+func (p *sfParser) parseParameters() (Params, error) {
+	params := Params{}
+	for p.consume(';') {
+		p.discardSP()
+		key, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+
+		var value any = true
+		if p.consume('=') {
+			value, err = p.parseBareItem()
+			if err != nil {
+				return nil, err
+			}
+		}
+		params = params.set(key, value)
+	}
+	return params, nil
+}
+
+// set overwrites an existing parameter in place or appends a new one.
+func (p Params) set(key string, value any) Params {
+	for i := range p {
+		if p[i].Key == key {
+			p[i].Value = value
+			return p
+		}
+	}
+	return append(p, Param{Key: key, Value: value})
+}
+
+func (p *sfParser) parseKey() (string, error) {
+	c, ok := p.peek()
+	if !ok || !(isLCAlpha(c) || c == '*') {
+		return "", p.fail("invalid key")
+	}
+
+	i := 1
+	for i < len(p.input) && isKeyChar(p.input[i]) {
+		i++
+	}
+	key := p.input[:i]
+	p.input = p.input[i:]
+	return key, nil
+}
+
+func (p *sfParser) parseBareItem() (any, error) {
+	c, ok := p.peek()
+	switch {
+	case !ok:
+		return nil, p.fail("missing item")
+	case c == '-' || isDigit(c):
+		return p.parseNumber()
+	case c == '"':
+		return p.parseString()
+	case c == '*' || isAlpha(c):
+		return p.parseToken(), nil
+	case c == ':':
+		return p.parseByteSequence()
+	case c == '?':
+		return p.parseBoolean()
+	}
+	return nil, p.fail("invalid item")
+}
+
+func (p *sfParser) parseNumber() (any, error) {
+	i := 0
+	if p.input[0] == '-' {
+		i++
+	}
+	if i == len(p.input) || !isDigit(p.input[i]) {
+		return nil, p.fail("invalid number")
+	}
+
+	start := i
+	dot := -1
+	for ; i < len(p.input); i++ {
+		c := p.input[i]
+		if isDigit(c) {
+			continue
+		}
+		if c != '.' || dot >= 0 {
+			break
+		}
+		if i-start > 12 {
+			return nil, p.fail("decimal integer component too long")
+		}
+		dot = i
+	}
+
+	number := p.input[:i]
+	if dot < 0 {
+		if i-start > 15 {
+			return nil, p.fail("integer too long")
+		}
+		p.input = p.input[i:]
+		n, err := strconv.ParseInt(number, 10, 64)
+		if err != nil {
+			return nil, p.fail("invalid integer")
+		}
+		return n, nil
+	}
+
+	if i-start > 16 {
+		return nil, p.fail("decimal too long")
+	}
+	fractional := i - dot - 1
+	if fractional == 0 || fractional > 3 {
+		return nil, p.fail("invalid decimal fraction")
+	}
+	p.input = p.input[i:]
+	f, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return nil, p.fail("invalid decimal")
+	}
+	return f, nil
+}
+
+func (p *sfParser) parseString() (string, error) {
+	p.consume('"')
+	var b strings.Builder
+	for i := 0; i < len(p.input); i++ {
+		c := p.input[i]
+		switch {
+		case c == '\\':
+			i++
+			if i == len(p.input) || (p.input[i] != '"' && p.input[i] != '\\') {
+				return "", p.fail("invalid escape in string")
+			}
+			b.WriteByte(p.input[i])
+		case c == '"':
+			p.input = p.input[i+1:]
+			return b.String(), nil
+		case c < 0x20 || c > 0x7e:
+			return "", p.fail("invalid character in string")
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", p.fail("unterminated string")
+}
+
+func (p *sfParser) parseToken() Token {
+	i := 1
+	for i < len(p.input) && isTokenChar(p.input[i]) {
+		i++
+	}
+	token := p.input[:i]
+	p.input = p.input[i:]
+	return Token(token)
+}
+
+func (p *sfParser) parseByteSequence() ([]byte, error) {
+	p.consume(':')
+	end := strings.IndexByte(p.input, ':')
+	if end < 0 {
+		return nil, p.fail("unterminated byte sequence")
+	}
+
+	encoded := p.input[:end]
+	for i := 0; i < len(encoded); i++ {
+		c := encoded[i]
+		if !isAlpha(c) && !isDigit(c) && c != '+' && c != '/' && c != '=' {
+			return nil, p.fail("invalid character in byte sequence")
+		}
+	}
+
+	// Padding may be omitted, but not misplaced.
+	decoded, err := base64.RawStdEncoding.DecodeString(
+		strings.TrimRight(encoded, "="),
+	)
+	if err != nil {
+		return nil, p.fail("invalid byte sequence")
+	}
+	p.input = p.input[end+1:]
+	return decoded, nil
+}
+
+func (p *sfParser) parseBoolean() (bool, error) {
+	p.consume('?')
+	switch {
+	case p.consume('1'):
+		return true, nil
+	case p.consume('0'):
+		return false, nil
+	}
+	return false, p.fail("invalid boolean")
+}
+
+func (i Item) serialize(b *strings.Builder) error {
+	return i.serializeMember(b)
+}
+
+func (l List) serialize(b *strings.Builder) error {
+	for i, member := range l {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		err := member.serializeMember(b)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d Dictionary) serialize(b *strings.Builder) error {
+	for i, member := range d {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		err := serializeKey(b, member.Key)
+		if err != nil {
+			return err
+		}
+
+		if item, ok := member.Value.(Item); ok && item.Value == true {
+			err = item.Params.serialize(b)
+		} else {
+			b.WriteByte('=')
+			err = member.Value.serializeMember(b)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (i Item) serializeMember(b *strings.Builder) error {
+	err := serializeBareItem(b, i.Value)
+	if err != nil {
+		return err
+	}
+	return i.Params.serialize(b)
+}
+
+func (il InnerList) serializeMember(b *strings.Builder) error {
+	b.WriteByte('(')
+	for i, item := range il.Items {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		err := item.serializeMember(b)
+		if err != nil {
+			return err
+		}
+	}
+	b.WriteByte(')')
+	return il.Params.serialize(b)
+}
+
+func (p Params) serialize(b *strings.Builder) error {
+	for _, param := range p {
+		b.WriteByte(';')
+		err := serializeKey(b, param.Key)
+		if err != nil {
+			return err
+		}
+		if param.Value == true {
+			continue
+		}
+
+		b.WriteByte('=')
+		err = serializeBareItem(b, param.Value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func serializeKey(b *strings.Builder, key string) error {
+	if key == "" || !(isLCAlpha(key[0]) || key[0] == '*') ||
+		strings.IndexFunc(key, func(r rune) bool {
+			return r > 0x7f || !isKeyChar(byte(r))
+		}) >= 0 {
+		return fmt.Errorf("%w: invalid key %q", ErrInvalidStructuredField, key)
+	}
+	b.WriteString(key)
+	return nil
+}
+
+func serializeBareItem(b *strings.Builder, value any) error {
+	switch v := value.(type) {
+	case int:
+		return serializeInteger(b, int64(v))
+	case int64:
+		return serializeInteger(b, v)
+	case float64:
+		return serializeDecimal(b, v)
+	case string:
+		return serializeString(b, v)
+	case Token:
+		return serializeToken(b, v)
+	case []byte:
+		b.WriteByte(':')
+		b.WriteString(base64.StdEncoding.EncodeToString(v))
+		b.WriteByte(':')
+	case bool:
+		if v {
+			b.WriteString("?1")
+		} else {
+			b.WriteString("?0")
+		}
+	default:
+		return fmt.Errorf(
+			"%w: unsupported item type %T",
+			ErrInvalidStructuredField,
+			value,
+		)
+	}
+	return nil
+}
+
+const maxInteger = 999_999_999_999_999
+
+func serializeInteger(b *strings.Builder, n int64) error {
+	if n < -maxInteger || n > maxInteger {
+		return fmt.Errorf("%w: integer out of range", ErrInvalidStructuredField)
+	}
+	b.WriteString(strconv.FormatInt(n, 10))
+	return nil
+}
+
+// serializeDecimal rounds to three fractional digits, ties to even, and
+// writes at least one fractional digit.
+func serializeDecimal(b *strings.Builder, f float64) error {
+	rounded := math.RoundToEven(f*1000) / 1000
+	if math.IsNaN(rounded) || math.Abs(rounded) >= 1e12 {
+		return fmt.Errorf("%w: decimal out of range", ErrInvalidStructuredField)
+	}
+
+	text := strconv.FormatFloat(rounded, 'f', 3, 64)
+	text = strings.TrimRight(text, "0")
+	if strings.HasSuffix(text, ".") {
+		text += "0"
+	}
+	b.WriteString(text)
+	return nil
+}
+
+func serializeString(b *strings.Builder, s string) error {
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 0x20 || c > 0x7e {
+			return fmt.Errorf(
+				"%w: invalid character in string",
+				ErrInvalidStructuredField,
+			)
+		}
+		if c == '"' || c == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+	b.WriteByte('"')
+	return nil
+}
+
+func serializeToken(b *strings.Builder, t Token) error {
+	if t == "" || !(isAlpha(t[0]) || t[0] == '*') {
+		return fmt.Errorf("%w: invalid token %q", ErrInvalidStructuredField, t)
+	}
+	for i := 1; i < len(t); i++ {
+		if !isTokenChar(t[i]) {
+			return fmt.Errorf("%w: invalid token %q", ErrInvalidStructuredField, t)
+		}
+	}
+	b.WriteString(string(t))
+	return nil
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isLCAlpha(c byte) bool {
+	return 'a' <= c && c <= 'z'
+}
+
+func isAlpha(c byte) bool {
+	return isLCAlpha(c) || ('A' <= c && c <= 'Z')
+}
+
+func isKeyChar(c byte) bool {
+	return isLCAlpha(c) || isDigit(c) || strings.IndexByte("_-.*", c) >= 0
+}
+
+// isTokenChar reports whether c may follow the first character of an sf-token:
+// tchar, ":" or "/".
+func isTokenChar(c byte) bool {
+	return c < 0x80 && (IsToken(string(c)) || c == ':' || c == '/')
+}
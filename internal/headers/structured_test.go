@@ -0,0 +1,317 @@
+package headers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sfVectors are taken from the examples in RFC 8941 and the HTTP working
+// group's structured field test suite. Each raw value is parsed as kind,
+// then must fail or serialize to canonical.
+var sfVectors = []struct {
+	name      string
+	raw       []string
+	kind      string
+	canonical string
+	fail      bool
+}{
+	// Integers
+	{"basic integer", []string{"42"}, "item", "42", false},
+	{"zero integer", []string{"0"}, "item", "0", false},
+	{"negative zero", []string{"-0"}, "item", "0", false},
+	{"double negative zero", []string{"--0"}, "item", "", true},
+	{"negative integer", []string{"-42"}, "item", "-42", false},
+	{"leading 0 integer", []string{"042"}, "item", "42", false},
+	{"leading 0 negative integer", []string{"-042"}, "item", "-42", false},
+	{"comma", []string{"2,3"}, "item", "", true},
+	{"negative non-DIGIT first character", []string{"-a23"}, "item", "", true},
+	{"sign out of place", []string{"4-2"}, "item", "", true},
+	{"long integer", []string{"123456789012345"}, "item", "123456789012345", false},
+	{"long negative integer", []string{"-123456789012345"}, "item", "-123456789012345", false},
+	{"too long integer", []string{"1234567890123456"}, "item", "", true},
+	{"negative too long integer", []string{"-1234567890123456"}, "item", "", true},
+	{"simple decimal", []string{"1.23"}, "item", "1.23", false},
+	{"negative decimal", []string{"-1.23"}, "item", "-1.23", false},
+	{"decimal, whole", []string{"1.0"}, "item", "1.0", false},
+	{"decimal with three fractional digits", []string{"1.123"}, "item", "1.123", false},
+	{"decimal with four fractional digits", []string{"1.1234"}, "item", "", true},
+	{"decimal with trailing dot", []string{"1."}, "item", "", true},
+	{"decimal with double dot", []string{"1.5.4"}, "item", "", true},
+	{"decimal with 12 integer digits", []string{"123456789012.1"}, "item", "123456789012.1", false},
+	{"decimal with 13 integer digits", []string{"1234567890123.0"}, "item", "", true},
+	{"longest decimal", []string{"123456789012.123"}, "item", "123456789012.123", false},
+	{"decimal, trailing zeros", []string{"2.500"}, "item", "2.5", false},
+
+	// Strings
+	{"basic string", []string{`"foo bar"`}, "item", `"foo bar"`, false},
+	{"empty string", []string{`""`}, "item", `""`, false},
+	{"long string", []string{`"aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"`}, "item", `"aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"`, false},
+	{"whitespace string", []string{`"   "`}, "item", `"   "`, false},
+	{"non-ascii string", []string{"\"füü\""}, "item", "", true},
+	{"tab in string", []string{"\"\t\""}, "item", "", true},
+	{"newline in string", []string{"\" \n \""}, "item", "", true},
+	{"single quoted string", []string{`'foo'`}, "item", "", true},
+	{"unbalanced string", []string{`"foo`}, "item", "", true},
+	{"string quoting", []string{`"foo \"bar\" \\ baz"`}, "item", `"foo \"bar\" \\ baz"`, false},
+	{"bad string quoting", []string{`"foo \,"`}, "item", "", true},
+	{"ending string quote", []string{`"foo \"`}, "item", "", true},
+	{"abruptly ending string quote", []string{`"foo \`}, "item", "", true},
+
+	// Tokens
+	{"basic token", []string{"a_b-c.d3:f%00/*"}, "item", "a_b-c.d3:f%00/*", false},
+	{"token with capitals", []string{"fooBar"}, "item", "fooBar", false},
+	{"token starting with capitals", []string{"FooBar"}, "item", "FooBar", false},
+	{"token starting with star", []string{"*foo"}, "item", "*foo", false},
+	{"token starting with digit", []string{"1foo"}, "item", "", true},
+
+	// Byte sequences
+	{"basic binary", []string{":aGVsbG8=:"}, "item", ":aGVsbG8=:", false},
+	{"empty binary", []string{"::"}, "item", "::", false},
+	{"bad paddding", []string{":aGVsbG8:"}, "item", ":aGVsbG8=:", false},
+	{"bad end delimiter", []string{":aGVsbG8="}, "item", "", true},
+	{"extra whitespace", []string{":aGVsb G8=:"}, "item", "", true},
+	{"all chars in binary", []string{":iZtDfD/+w8b9XFSqDL51vB2sM7VmKPl0CEXLmQ==:"}, "item", ":iZtDfD/+w8b9XFSqDL51vB2sM7VmKPl0CEXLmQ==:", false},
+	{"padding at beginning", []string{":=aGVsbG8=:"}, "item", "", true},
+	{"non-base64 character", []string{":aGVsbG8.:"}, "item", "", true},
+
+	// Booleans
+	{"basic true boolean", []string{"?1"}, "item", "?1", false},
+	{"basic false boolean", []string{"?0"}, "item", "?0", false},
+	{"unknown boolean", []string{"?Q"}, "item", "", true},
+	{"whitespace boolean", []string{"? 1"}, "item", "", true},
+	{"negative zero boolean", []string{"?-0"}, "item", "", true},
+	{"T boolean", []string{"?T"}, "item", "", true},
+	{"truncated boolean", []string{"?"}, "item", "", true},
+
+	// Items
+	{"empty item", []string{""}, "item", "", true},
+	{"leading space", []string{"  1"}, "item", "1", false},
+	{"trailing space", []string{"1  "}, "item", "1", false},
+	{"leading and trailing space", []string{"  1  "}, "item", "1", false},
+	{"leading tab", []string{"\t1"}, "item", "", true},
+	{"two items", []string{"1, 2"}, "item", "", true},
+
+	// Lists
+	{"basic list", []string{"1, 42"}, "list", "1, 42", false},
+	{"empty list", []string{""}, "list", "", false},
+	{"leading SP list", []string{"  42, 43"}, "list", "42, 43", false},
+	{"single item list", []string{"42"}, "list", "42", false},
+	{"no whitespace list", []string{"1,42"}, "list", "1, 42", false},
+	{"extra whitespace list", []string{"1 , 42"}, "list", "1, 42", false},
+	{"tab separated list", []string{"1\t,\t42"}, "list", "1, 42", false},
+	{"two line list", []string{"1", "42"}, "list", "1, 42", false},
+	{"trailing comma list", []string{"1, 42,"}, "list", "", true},
+	{"empty item list", []string{"1,,42"}, "list", "", true},
+	{"empty list item in two lines", []string{"1", ""}, "list", "", true},
+
+	// Inner lists
+	{"basic list of lists", []string{"(1 2), (42 43)"}, "list", "(1 2), (42 43)", false},
+	{"single item list of lists", []string{"(42)"}, "list", "(42)", false},
+	{"empty item list of lists", []string{"()"}, "list", "()", false},
+	{"empty middle item list of lists", []string{"(1),(),(42)"}, "list", "(1), (), (42)", false},
+	{"extra whitespace list of lists", []string{"(  1  42  )"}, "list", "(1 42)", false},
+	{"wrong whitespace list of lists", []string{"(1\t 42)"}, "list", "", true},
+	{"no trailing parenthesis list of lists", []string{"(1 42"}, "list", "", true},
+	{"no trailing parenthesis middle list of lists", []string{"(1 2, (42 43)"}, "list", "", true},
+	{"no spaces in inner-list", []string{"(abc\"def\"?0123*dXZ3*xyz)"}, "list", "", true},
+	{"no closing parenthesis", []string{"("}, "list", "", true},
+
+	// Dictionaries
+	{"basic dictionary", []string{`en="Applepie", da=:w4ZibGV0w6ZydGU=:`}, "dictionary", `en="Applepie", da=:w4ZibGV0w6ZydGU=:`, false},
+	{"empty dictionary", []string{""}, "dictionary", "", false},
+	{"single item dictionary", []string{"a=1"}, "dictionary", "a=1", false},
+	{"list item dictionary", []string{"a=(1 2)"}, "dictionary", "a=(1 2)", false},
+	{"single list item dictionary", []string{"a=(1)"}, "dictionary", "a=(1)", false},
+	{"empty list item dictionary", []string{"a=()"}, "dictionary", "a=()", false},
+	{"no whitespace dictionary", []string{"a=1,b=2"}, "dictionary", "a=1, b=2", false},
+	{"extra whitespace dictionary", []string{"a=1 ,  b=2"}, "dictionary", "a=1, b=2", false},
+	{"tab separated dictionary", []string{"a=1\t,\tb=2"}, "dictionary", "a=1, b=2", false},
+	{"leading whitespace dictionary", []string{"     a=1 ,  b=2"}, "dictionary", "a=1, b=2", false},
+	{"whitespace before = dictionary", []string{"a =1, b=2"}, "dictionary", "", true},
+	{"whitespace after = dictionary", []string{"a=1, b= 2"}, "dictionary", "", true},
+	{"two lines dictionary", []string{"a=1", "b=2"}, "dictionary", "a=1, b=2", false},
+	{"missing value dictionary", []string{"a=1, b, c=3"}, "dictionary", "a=1, b, c=3", false},
+	{"all missing value dictionary", []string{"a, b, c"}, "dictionary", "a, b, c", false},
+	{"start missing value dictionary", []string{"a, b=2"}, "dictionary", "a, b=2", false},
+	{"end missing value dictionary", []string{"a=1, b"}, "dictionary", "a=1, b", false},
+	{"missing value with params dictionary", []string{"a=1, b;foo=9, c=3"}, "dictionary", "a=1, b;foo=9, c=3", false},
+	{"explicit true value with params dictionary", []string{"a=1, b=?1;foo=9, c=3"}, "dictionary", "a=1, b;foo=9, c=3", false},
+	{"trailing comma dictionary", []string{"a=1, b=2,"}, "dictionary", "", true},
+	{"empty item dictionary", []string{"a=1,,b=2,"}, "dictionary", "", true},
+	{"duplicate key dictionary", []string{"a=1,b=2,a=3"}, "dictionary", "a=3, b=2", false},
+	{"numeric key dictionary", []string{"a=1,1b=2,a=1"}, "dictionary", "", true},
+	{"uppercase key dictionary", []string{"a=1,B=2,a=1"}, "dictionary", "", true},
+	{"bad key dictionary", []string{"a=1,b!=2,a=1"}, "dictionary", "", true},
+
+	// Parameters
+	{"basic parameterised list", []string{`abc;a=1;b=2; cde_456, (ghi;jk=4 l);q="9";r=w`}, "list", `abc;a=1;b=2;cde_456, (ghi;jk=4 l);q="9";r=w`, false},
+	{"single item parameterised list", []string{"text/html;q=1.0"}, "list", "text/html;q=1.0", false},
+	{"missing parameter value parameterised list", []string{"text/html;a;q=1.0"}, "list", "text/html;a;q=1.0", false},
+	{"missing terminal parameter value parameterised list", []string{"text/html;q=1.0;a"}, "list", "text/html;q=1.0;a", false},
+	{"no whitespace parameterised list", []string{"text/html,text/plain;q=0.5"}, "list", "text/html, text/plain;q=0.5", false},
+	{"whitespace before = parameterised list", []string{"text/html, text/plain;q =0.5"}, "list", "", true},
+	{"whitespace after = parameterised list", []string{"text/html, text/plain;q= 0.5"}, "list", "", true},
+	{"whitespace before ; parameterised list", []string{"text/html, text/plain ;q=0.5"}, "list", "", true},
+	{"whitespace after ; parameterised list", []string{"text/html, text/plain; q=0.5"}, "list", "text/html, text/plain;q=0.5", false},
+	{"extra whitespace parameterised list", []string{"text/html  ,  text/plain;  q=0.5;  charset=utf-8"}, "list", "text/html, text/plain;q=0.5;charset=utf-8", false},
+	{"two lines parameterised list", []string{"text/html", "text/plain;q=0.5"}, "list", "text/html, text/plain;q=0.5", false},
+	{"trailing comma parameterised list", []string{"text/html,text/plain;q=0.5,"}, "list", "", true},
+	{"duplicate parameter", []string{"abc;a=1;b=2;a=3"}, "item", "abc;a=3;b=2", false},
+	{"parameterised inner list", []string{"(abc;a=1;b=2);cde_456"}, "list", "(abc;a=1;b=2);cde_456", false},
+
+	// RFC 8941 examples
+	{"Foo-Example", []string{`2; foourl="https://foo.example.com/"`}, "item", `2;foourl="https://foo.example.com/"`, false},
+	{"Example-StrListHeader", []string{`"foo", "bar", "It was the best of times."`}, "list", `"foo", "bar", "It was the best of times."`, false},
+	{"Example-Hdr (list on one line)", []string{"foo, bar"}, "list", "foo, bar", false},
+	{"Example-Hdr (list on two lines)", []string{"foo", "bar"}, "list", "foo, bar", false},
+	{"Example-StrListListHeader", []string{`("foo" "bar"), ("baz"), ("bat" "one"), ()`}, "list", `("foo" "bar"), ("baz"), ("bat" "one"), ()`, false},
+	{"Example-ListListParam", []string{`("foo"; a=1;b=2);lvl=5, ("bar" "baz");lvl=1`}, "list", `("foo";a=1;b=2);lvl=5, ("bar" "baz");lvl=1`, false},
+	{"Example-ParamListHeader", []string{"abc;a=1;b=2; cde_456, (ghi;jk=4 l);q=\"9\";r=w"}, "list", "abc;a=1;b=2;cde_456, (ghi;jk=4 l);q=\"9\";r=w", false},
+	{"Example-IntHeader", []string{"1; a; b=?0"}, "item", "1;a;b=?0", false},
+	{"Example-DictHeader", []string{`en="Applepie", da=:w4ZibGV0w6ZydGU=:`}, "dictionary", `en="Applepie", da=:w4ZibGV0w6ZydGU=:`, false},
+	{"Example-DictHeader (boolean values)", []string{"a=?0, b, c; foo=bar"}, "dictionary", "a=?0, b, c;foo=bar", false},
+	{"Example-DictListHeader", []string{"rating=1.5, feelings=(joy sadness)"}, "dictionary", "rating=1.5, feelings=(joy sadness)", false},
+	{"Example-MixDict", []string{"a=(1 2), b=3, c=4;aa=bb, d=(5 6);valid"}, "dictionary", "a=(1 2), b=3, c=4;aa=bb, d=(5 6);valid", false},
+	{"Example-Hdr (dictionary on one line)", []string{"foo=1, bar=2"}, "dictionary", "foo=1, bar=2", false},
+	{"Example-Hdr (dictionary on two lines)", []string{"foo=1", "bar=2"}, "dictionary", "foo=1, bar=2", false},
+	{"Example-IntItemHeader", []string{"5"}, "item", "5", false},
+	{"Example-IntItemHeader (params)", []string{"5; foo=bar"}, "item", "5;foo=bar", false},
+	{"Example-IntegerHeader", []string{"42"}, "item", "42", false},
+	{"Example-DecimalHeader", []string{"4.5"}, "item", "4.5", false},
+	{"Example-StringHeader", []string{`"hello world"`}, "item", `"hello world"`, false},
+	{"Example-TokenHeader", []string{"foo123/456"}, "item", "foo123/456", false},
+	{"Example-ByteSequenceHeader", []string{":cHJldGVuZCB0aGlzIGlzIGJpbmFyeSBjb250ZW50Lg==:"}, "item", ":cHJldGVuZCB0aGlzIGlzIGJpbmFyeSBjb250ZW50Lg==:", false},
+	{"Example-BoolHeader", []string{"?1"}, "item", "?1", false},
+}
+
+func TestStructuredFieldVectors(t *testing.T) {
+	for _, tt := range sfVectors {
+		h := NewHeaders()
+		for _, line := range tt.raw {
+			h.Add("Example", line)
+		}
+
+		var sf StructuredField
+		var err error
+		switch tt.kind {
+		case "item":
+			sf, _, err = h.StructuredItem("Example")
+		case "list":
+			sf, _, err = h.StructuredList("Example")
+		case "dictionary":
+			sf, _, err = h.StructuredDictionary("Example")
+		}
+
+		// Test: Invalid value fails to parse
+		if tt.fail {
+			assert.ErrorIs(t, err, ErrInvalidStructuredField, tt.name)
+			continue
+		}
+
+		// Test: Valid value serializes to its canonical form
+		require.NoError(t, err, tt.name)
+		got, err := Serialize(sf)
+		require.NoError(t, err, tt.name)
+		assert.Equal(t, tt.canonical, got, tt.name)
+	}
+}
+
+func TestStructuredFieldValues(t *testing.T) {
+	h := NewHeaders()
+	h.Set("Example-Dict", `a=1, b=2.5, c="x", d=tok, e=:AQI=:, f=?0, g;p=1, h=(1 "s");q`)
+
+	// Test: Bare items parse to their Go types
+	dict, ok, err := h.StructuredDictionary("example-dict")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	want := map[string]any{
+		"a": int64(1),
+		"b": 2.5,
+		"c": "x",
+		"d": Token("tok"),
+		"e": []byte{1, 2},
+		"f": false,
+		"g": true,
+	}
+	for key, value := range want {
+		member, ok := dict.Get(key)
+		require.True(t, ok, key)
+		assert.Equal(t, value, member.(Item).Value, key)
+	}
+	member, _ := dict.Get("g")
+	p, ok := member.(Item).Params.Get("p")
+	assert.True(t, ok)
+	assert.Equal(t, int64(1), p)
+
+	// Test: Inner list members and parameters
+	member, _ = dict.Get("h")
+	inner := member.(InnerList)
+	assert.Equal(t, []Item{
+		{Value: int64(1), Params: Params{}},
+		{Value: "s", Params: Params{}},
+	}, inner.Items)
+	q, _ := inner.Params.Get("q")
+	assert.Equal(t, true, q)
+
+	// Test: Missing field
+	_, ok, err = h.StructuredList("Priority")
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestSetStructured(t *testing.T) {
+	h := NewHeaders()
+
+	// Test: Values built in Go serialize onto the field
+	err := h.SetStructured("Priority", Dictionary{
+		{Key: "u", Value: Item{Value: 3}},
+		{Key: "i", Value: Item{Value: true}},
+	})
+	require.NoError(t, err)
+	priority, _ := h.Get("Priority")
+	assert.Equal(t, "u=3, i", priority)
+
+	err = h.SetStructured("Cache-Status", List{
+		Item{Value: Token("ExampleCache"), Params: Params{
+			{Key: "hit", Value: true},
+			{Key: "ttl", Value: 376},
+		}},
+	})
+	require.NoError(t, err)
+	cacheStatus, _ := h.Get("Cache-Status")
+	assert.Equal(t, "ExampleCache;hit;ttl=376", cacheStatus)
+
+	// Test: Decimals round to three places, ties to even
+	for value, want := range map[float64]string{
+		1.0:     "1.0",
+		1.5:     "1.5",
+		0.0005:  "0.0",
+		0.0015:  "0.002",
+		-2.2505: "-2.25",
+	} {
+		got, err := Serialize(Item{Value: value})
+		require.NoError(t, err)
+		assert.Equal(t, want, got, value)
+	}
+
+	// Test: Values outside the grammar cannot be serialized
+	for _, sf := range []StructuredField{
+		Item{Value: 1_000_000_000_000_000},
+		Item{Value: 1e12},
+		Item{Value: "café"},
+		Item{Value: Token("1abc")},
+		Item{Value: 1, Params: Params{{Key: "Upper", Value: true}}},
+		Dictionary{{Key: "", Value: Item{Value: 1}}},
+		Item{Value: struct{}{}},
+	} {
+		_, err := Serialize(sf)
+		assert.ErrorIs(t, err, ErrInvalidStructuredField)
+	}
+
+	// Test: Empty List removes the field
+	require.NoError(t, h.SetStructured("Cache-Status", List{}))
+	_, ok := h.Get("Cache-Status")
+	assert.False(t, ok)
+}
@@ -0,0 +1,284 @@
+package headers
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidValue is returned by the typed accessors when a field is
+// present but does not follow its grammar.
+var ErrInvalidValue = errors.New("invalid header field value")
+
+// TimeFormat is IMF-fixdate, the preferred format of an HTTP-date.
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// timeFormats are the HTTP-date formats a recipient must accept (RFC 9110
+// section 5.6.7): IMF-fixdate and the obsolete RFC 850 and asctime formats.
+var timeFormats = []string{
+	TimeFormat,
+	"Monday, 02-Jan-06 15:04:05 GMT",
+	"Mon Jan _2 15:04:05 2006",
+}
+
+// MediaType is a parsed media type such as a Content-Type value. Type and
+// parameter names are lowercase; parameter values are unquoted.
+type MediaType struct {
+	Type   string
+	Params map[string]string
+}
+
+// QualityValue is one element of a quality-weighted list such as Accept.
+// Q defaults to 1; an element with Q 0 is explicitly not acceptable.
+type QualityValue struct {
+	Value  string
+	Q      float64
+	Params map[string]string
+}
+
+// Int parses a field holding a non-negative decimal integer, such as
+// Content-Length or Age. ok is false if the field is missing.
+func (h Headers) Int(key string) (n int64, ok bool, err error) {
+	val, ok := h.Get(key)
+	if !ok {
+		return 0, false, nil
+	}
+
+	n, err = ParseInt(val)
+	if err != nil {
+		return 0, true, fmt.Errorf("headers.Int: %s: %w", key, err)
+	}
+	return n, true, nil
+}
+
+// ParseInt parses 1*DIGIT. Unlike strconv.ParseInt it rejects signs.
+func ParseInt(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.IndexFunc(s, isNotDigit) >= 0 {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidValue, s)
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidValue, s)
+	}
+	return n, nil
+}
+
+func isNotDigit(r rune) bool {
+	return r < '0' || r > '9'
+}
+
+// Time parses a field holding an HTTP-date, such as Date or Expires. ok is
+// false if the field is missing.
+func (h Headers) Time(key string) (t time.Time, ok bool, err error) {
+	val, ok := h.Get(key)
+	if !ok {
+		return time.Time{}, false, nil
+	}
+
+	t, err = ParseTime(val)
+	if err != nil {
+		return time.Time{}, true, fmt.Errorf("headers.Time: %s: %w", key, err)
+	}
+	return t, true, nil
+}
+
+// SetTime sets a field to t as an IMF-fixdate.
+func (h Headers) SetTime(key string, t time.Time) {
+	h.Set(key, FormatTime(t))
+}
+
+// ParseTime parses an HTTP-date in any of the three allowed formats.
+func ParseTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range timeFormats {
+		t, err := time.Parse(layout, s)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%w: %q", ErrInvalidValue, s)
+}
+
+// FormatTime formats t as an IMF-fixdate.
+func FormatTime(t time.Time) string {
+	return t.UTC().Format(TimeFormat)
+}
+
+// List returns the elements of a comma-separated list field, across every
+// value of the field. Commas inside quoted-strings do not split elements,
+// and empty elements are dropped (RFC 9110 section 5.6.1).
+func (h Headers) List(key string) []string {
+	var list []string
+	for _, val := range h.Values(key) {
+		for _, element := range splitQuoted(val, ',') {
+			element = strings.TrimSpace(element)
+			if element != "" {
+				list = append(list, element)
+			}
+		}
+	}
+	return list
+}
+
+// MediaType parses a field holding a media type with parameters, such as
+// Content-Type. ok is false if the field is missing.
+func (h Headers) MediaType(key string) (mt MediaType, ok bool, err error) {
+	val, ok := h.Get(key)
+	if !ok {
+		return MediaType{}, false, nil
+	}
+
+	mt, err = ParseMediaType(val)
+	if err != nil {
+		return MediaType{}, true, fmt.Errorf("headers.MediaType: %s: %w", key, err)
+	}
+	return mt, true, nil
+}
+
+// ParseMediaType parses type "/" subtype followed by parameters.
+func ParseMediaType(s string) (MediaType, error) {
+	parts := splitQuoted(s, ';')
+	mediaType := strings.ToLower(strings.TrimSpace(parts[0]))
+	typ, subtype, found := strings.Cut(mediaType, "/")
+	if !found || !IsToken(typ) || !IsToken(subtype) {
+		return MediaType{}, fmt.Errorf("%w: %q", ErrInvalidValue, s)
+	}
+
+	params, err := parseParams(parts[1:])
+	if err != nil {
+		return MediaType{}, fmt.Errorf("%w: %q", ErrInvalidValue, s)
+	}
+	return MediaType{Type: mediaType, Params: params}, nil
+}
+
+// Qualities parses a quality-weighted list field, such as Accept,
+// Accept-Encoding or Accept-Language, ordered from most to least preferred.
+// Elements with equal weight keep their order. Malformed elements are
+// skipped.
+func (h Headers) Qualities(key string) []QualityValue {
+	var values []QualityValue
+	for _, element := range h.List(key) {
+		parts := splitQuoted(element, ';')
+		qv := QualityValue{
+			Value: strings.TrimSpace(parts[0]),
+			Q:     1,
+		}
+		if qv.Value == "" {
+			continue
+		}
+
+		params, err := parseParams(parts[1:])
+		if err != nil {
+			continue
+		}
+		if weight, ok := params["q"]; ok {
+			qv.Q, err = parseQuality(weight)
+			if err != nil {
+				continue
+			}
+			delete(params, "q")
+		}
+		qv.Params = params
+
+		values = append(values, qv)
+	}
+
+	slices.SortStableFunc(values, func(a, b QualityValue) int {
+		return cmp.Compare(b.Q, a.Q)
+	})
+	return values
+}
+
+// parseQuality parses a qvalue: 0 or 1 with up to three decimals.
+func parseQuality(s string) (float64, error) {
+	whole, frac, _ := strings.Cut(s, ".")
+	if (whole != "0" && whole != "1") || len(frac) > 3 ||
+		strings.IndexFunc(frac, isNotDigit) >= 0 {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidValue, s)
+	}
+
+	q, err := strconv.ParseFloat(s, 64)
+	if err != nil || q > 1 {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidValue, s)
+	}
+	return q, nil
+}
+
+// parseParams parses name=value parameters. Names are lowercased and
+// quoted values are unquoted.
+func parseParams(parts []string) (map[string]string, error) {
+	params := make(map[string]string)
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		name, value, found := strings.Cut(part, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		value = strings.TrimSpace(value)
+		if !found || !IsToken(name) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidValue, part)
+		}
+
+		if strings.HasPrefix(value, `"`) {
+			unquoted, ok := unquote(value)
+			if !ok {
+				return nil, fmt.Errorf("%w: %q", ErrInvalidValue, part)
+			}
+			value = unquoted
+		} else if !IsToken(value) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidValue, part)
+		}
+
+		params[name] = value
+	}
+	return params, nil
+}
+
+// splitQuoted splits s at each sep that is not inside a quoted-string.
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quoted && s[i] == '\\':
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case !quoted && s[i] == sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// unquote removes the quotes and backslash escapes of a quoted-string.
+func unquote(s string) (string, bool) {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return "", false
+	}
+
+	var b strings.Builder
+	for i := 1; i < len(s)-1; i++ {
+		switch s[i] {
+		case '\\':
+			i++
+			if i == len(s)-1 {
+				return "", false
+			}
+		case '"':
+			return "", false
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String(), true
+}
@@ -0,0 +1,139 @@
+package headers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInt(t *testing.T) {
+	h := NewHeaders()
+	h.Set("Content-Length", "42")
+
+	// Test: Valid integer
+	n, ok, err := h.Int("content-length")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, int64(42), n)
+
+	// Test: Missing field
+	_, ok, err = h.Int("Age")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// Test: Signs, spaces inside and other text are rejected
+	for _, value := range []string{"-1", "+1", "1 2", "0x10", "", "1.5", "99999999999999999999"} {
+		h.Set("Content-Length", value)
+		_, ok, err = h.Int("Content-Length")
+		assert.True(t, ok, value)
+		assert.ErrorIs(t, err, ErrInvalidValue, value)
+	}
+}
+
+func TestTime(t *testing.T) {
+	want := time.Date(1994, 11, 6, 8, 49, 37, 0, time.UTC)
+
+	// Test: All three HTTP-date formats parse to the same time
+	for _, value := range []string{
+		"Sun, 06 Nov 1994 08:49:37 GMT",
+		"Sunday, 06-Nov-94 08:49:37 GMT",
+		"Sun Nov  6 08:49:37 1994",
+	} {
+		h := NewHeaders()
+		h.Set("Date", value)
+		got, ok, err := h.Time("Date")
+		require.NoError(t, err, value)
+		assert.True(t, ok)
+		assert.True(t, want.Equal(got), value)
+	}
+
+	// Test: Invalid date
+	h := NewHeaders()
+	h.Set("Expires", "0")
+	_, ok, err := h.Time("Expires")
+	assert.True(t, ok)
+	assert.ErrorIs(t, err, ErrInvalidValue)
+
+	// Test: SetTime writes an IMF-fixdate in GMT
+	h.SetTime("Date", want.In(time.FixedZone("EST", -5*60*60)))
+	date, _ := h.Get("Date")
+	assert.Equal(t, "Sun, 06 Nov 1994 08:49:37 GMT", date)
+}
+
+func TestList(t *testing.T) {
+	h := NewHeaders()
+	h.Add("If-None-Match", `"a,b", W/"c"`)
+	h.Add("If-None-Match", ` , "d\"e"`)
+
+	// Test: Commas inside quoted-strings do not split, empty elements drop
+	assert.Equal(t,
+		[]string{`"a,b"`, `W/"c"`, `"d\"e"`},
+		h.List("If-None-Match"),
+	)
+
+	// Test: Missing field
+	assert.Nil(t, h.List("Vary"))
+}
+
+func TestMediaType(t *testing.T) {
+	h := NewHeaders()
+	h.Set("Content-Type", `Multipart/Form-Data; Boundary="a;b \"c\""; charset=UTF-8`)
+
+	// Test: Type and parameter names are lowercased, values unquoted
+	mt, ok, err := h.MediaType("Content-Type")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "multipart/form-data", mt.Type)
+	assert.Equal(t, map[string]string{
+		"boundary": `a;b "c"`,
+		"charset":  "UTF-8",
+	}, mt.Params)
+
+	// Test: Malformed media types
+	for _, value := range []string{
+		"text",
+		"text/",
+		"text/plain; charset",
+		`text/plain; charset="utf-8`,
+		"text/plain; charset=a b",
+	} {
+		_, err = ParseMediaType(value)
+		assert.ErrorIs(t, err, ErrInvalidValue, value)
+	}
+
+	// Test: Missing field
+	_, ok, err = h.MediaType("Accept")
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestQualities(t *testing.T) {
+	h := NewHeaders()
+	h.Set("Accept", "text/html;level=1, text/*;q=0.3, */*;q=0, application/json;q=0.9")
+	h.Add("Accept", "image/png; q=1.5, text/plain")
+
+	// Test: Elements are ordered by weight, ties keep their order
+	got := h.Qualities("Accept")
+	require.Len(t, got, 5)
+	assert.Equal(t, QualityValue{
+		Value:  "text/html",
+		Q:      1,
+		Params: map[string]string{"level": "1"},
+	}, got[0])
+	assert.Equal(t, "text/plain", got[1].Value)
+	assert.Equal(t, "application/json", got[2].Value)
+	assert.Equal(t, 0.9, got[2].Q)
+	assert.Equal(t, "text/*", got[3].Value)
+	assert.Equal(t, "*/*", got[4].Value)
+	assert.Equal(t, 0.0, got[4].Q)
+
+	// Test: Language and encoding lists
+	h.Set("Accept-Encoding", "gzip;q=0.5, br, identity;q=0.001")
+	var encodings []string
+	for _, qv := range h.Qualities("Accept-Encoding") {
+		encodings = append(encodings, qv.Value)
+	}
+	assert.Equal(t, []string{"br", "gzip", "identity"}, encodings)
+}
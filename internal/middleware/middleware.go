@@ -0,0 +1,130 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"strconv"
+	"time"
+
+	"github.com/davidw1457/httpfromtcp/internal/request"
+	"github.com/davidw1457/httpfromtcp/internal/response"
+	"github.com/davidw1457/httpfromtcp/internal/server"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// AccessLog logs one line per request once the inner handler has returned.
+func AccessLog(logger *log.Logger) server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			start := time.Now()
+			next(w, req)
+
+			requestID, ok := req.Headers.Get(requestIDHeader)
+			if !ok {
+				requestID = "-"
+			}
+
+			logger.Printf(
+				"%s %s %s %d %d %s %s\n",
+				req.RemoteAddr,
+				req.RequestLine.Method,
+				req.RequestLine.RequestTarget,
+				w.StatusCode(),
+				w.BytesWritten(),
+				time.Since(start),
+				requestID,
+			)
+		}
+	}
+}
+
+// Recover turns a panic in the inner handler into a 500 response, logging
+// the panic to logger. If the handler already started its response, nothing
+// more is written and the incomplete response causes the server to close
+// the connection.
+func Recover(logger *log.Logger) server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			defer func() {
+				v := recover()
+				if v == nil {
+					return
+				}
+
+				logger.Printf(
+					"middleware.Recover: panic serving %s: %v\n%s",
+					req.RequestLine.RequestTarget,
+					v,
+					debug.Stack(),
+				)
+
+				if w.StatusCode() != 0 {
+					return
+				}
+				writeServerError(w, logger)
+			}()
+
+			next(w, req)
+		}
+	}
+}
+
+// RequestID makes sure every request carries an X-Request-ID header,
+// generating one when the client did not send it, and echoes it in the
+// response.
+func RequestID() server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			requestID, ok := req.Headers.Get(requestIDHeader)
+			if !ok || requestID == "" {
+				requestID = newRequestID()
+				req.Headers.Set(requestIDHeader, requestID)
+			}
+
+			w.Header().Set(requestIDHeader, requestID)
+			next(w, req)
+		}
+	}
+}
+
+func newRequestID() string {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+
+	return hex.EncodeToString(id)
+}
+
+func writeServerError(w *response.Writer, logger *log.Logger) {
+	body := []byte(fmt.Sprintf(
+		"%d %s\n",
+		response.SERVERERROR,
+		response.StatusText(response.SERVERERROR),
+	))
+
+	err := w.WriteStatusLine(response.SERVERERROR)
+	if err != nil {
+		logger.Printf("middleware.writeServerError: %s\n", err)
+		return
+	}
+
+	headers := response.GetDefaultHeaders(len(body))
+	headers.Set("Connection", "close")
+
+	err = w.WriteHeaders(headers)
+	if err != nil {
+		logger.Printf("middleware.writeServerError: %s\n", err)
+		return
+	}
+
+	_, err = w.WriteBody(body)
+	if err != nil {
+		logger.Printf("middleware.writeServerError: %s\n", err)
+	}
+}
@@ -0,0 +1,118 @@
+package middleware
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/davidw1457/httpfromtcp/internal/request"
+	"github.com/davidw1457/httpfromtcp/internal/response"
+	"github.com/davidw1457/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChainOrder(t *testing.T) {
+	var calls []string
+	mark := func(name string) server.Middleware {
+		return func(next server.Handler) server.Handler {
+			return func(w *response.Writer, req *request.Request) {
+				calls = append(calls, name+" before")
+				next(w, req)
+				calls = append(calls, name+" after")
+			}
+		}
+	}
+
+	handler := server.Chain(
+		func(w *response.Writer, req *request.Request) {
+			calls = append(calls, "handler")
+		},
+		mark("outer"),
+		mark("inner"),
+	)
+	serve(t, handler, "GET / HTTP/1.1\r\n\r\n")
+
+	assert.Equal(t, []string{
+		"outer before",
+		"inner before",
+		"handler",
+		"inner after",
+		"outer after",
+	}, calls)
+}
+
+func TestAccessLog(t *testing.T) {
+	logs := &bytes.Buffer{}
+	handler := server.Chain(ok("hello"), AccessLog(log.New(logs, "", 0)))
+
+	out := serve(t, handler, "GET /greeting HTTP/1.1\r\n\r\n")
+	assert.Contains(t, out, "HTTP/1.1 200 OK\r\n")
+	assert.Contains(t, logs.String(), "GET /greeting 200 5 ")
+}
+
+func TestRecover(t *testing.T) {
+	logs := &bytes.Buffer{}
+	logger := log.New(logs, "", 0)
+
+	// Test: Panic before the status line becomes a 500
+	handler := server.Chain(
+		func(w *response.Writer, req *request.Request) {
+			panic("boom")
+		},
+		Recover(logger),
+	)
+	out := serve(t, handler, "GET / HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 500 Internal Server Error\r\n"))
+	assert.Contains(t, out, "Connection: close\r\n")
+	assert.Contains(t, logs.String(), "panic serving /: boom")
+
+	// Test: Panic after the status line leaves the response alone
+	handler = server.Chain(
+		func(w *response.Writer, req *request.Request) {
+			_ = w.WriteStatusLine(response.OK)
+			panic("boom")
+		},
+		Recover(logger),
+	)
+	out = serve(t, handler, "GET / HTTP/1.1\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", out)
+}
+
+func TestRequestID(t *testing.T) {
+	// Test: Generated request ID is visible to the handler and the client
+	var seen string
+	handler := server.Chain(
+		func(w *response.Writer, req *request.Request) {
+			seen, _ = req.Headers.Get("X-Request-ID")
+			ok("")(w, req)
+		},
+		RequestID(),
+	)
+	out := serve(t, handler, "GET / HTTP/1.1\r\n\r\n")
+	assert.Len(t, seen, 32)
+	assert.Contains(t, out, "X-Request-ID: "+seen+"\r\n")
+
+	// Test: Client-supplied request ID is kept
+	out = serve(t, handler, "GET / HTTP/1.1\r\nX-Request-ID: abc\r\n\r\n")
+	assert.Equal(t, "abc", seen)
+	assert.Contains(t, out, "X-Request-ID: abc\r\n")
+}
+
+func ok(body string) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		_ = w.WriteStatusLine(response.OK)
+		_ = w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		_, _ = w.WriteBody([]byte(body))
+	}
+}
+
+func serve(t *testing.T, handler server.Handler, raw string) string {
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	handler(response.NewWriter(buf), req)
+	return buf.String()
+}
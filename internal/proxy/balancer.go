@@ -0,0 +1,441 @@
+package proxy
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/davidw1457/httpfromtcp/internal/client"
+	"github.com/davidw1457/httpfromtcp/internal/request"
+	"github.com/davidw1457/httpfromtcp/internal/response"
+)
+
+const (
+	defaultMaxFails            = 3
+	defaultEjectDuration       = 30 * time.Second
+	defaultHealthCheckInterval = 10 * time.Second
+	defaultHealthCheckTimeout  = 2 * time.Second
+	// hashReplicas is the number of points each upstream gets on the
+	// consistent hash ring, which evens out the share of keys each owns.
+	hashReplicas = 100
+)
+
+// ErrNoUpstream is returned, wrapped, when every upstream is unhealthy,
+// ejected or has already failed the request.
+var ErrNoUpstream = errors.New("no upstream available")
+
+// Policy selects how a Balancer spreads requests over its upstreams.
+type Policy int
+
+const (
+	RoundRobin Policy = iota
+	LeastConnections
+	// ConsistentHash sends requests with the same key, the HashHeader
+	// value or the client IP, to the same upstream while it is available.
+	ConsistentHash
+)
+
+// Upstream is a single server behind a Balancer. The zero value of
+// everything but URL is ready to use: a new upstream counts as up until a
+// health check or its failures say otherwise.
+type Upstream struct {
+	URL *url.URL
+
+	active atomic.Int64
+
+	mu           sync.Mutex
+	down         bool
+	failures     int
+	ejectedUntil time.Time
+}
+
+// Balancer picks an upstream for each request a ReverseProxy forwards.
+// Build one with NewBalancer or as a struct literal; Upstreams may be
+// appended to before it serves requests.
+//
+// An upstream is skipped while the active health check reports it down,
+// or for EjectDuration after MaxFails consecutive requests to it failed
+// with a connection error. Requests with an idempotent method and no body,
+// or a body the server already buffered, are retried on another upstream
+// when one fails.
+type Balancer struct {
+	Upstreams []*Upstream
+	Policy    Policy
+	// HashHeader names the request header keying ConsistentHash; the
+	// client IP is used when it is empty or missing from the request.
+	HashHeader string
+
+	// MaxFails defaults to 3 and EjectDuration to 30 seconds.
+	MaxFails      int
+	EjectDuration time.Duration
+
+	// HealthCheckPath enables active health checks when set: every
+	// HealthCheckInterval, default 10 seconds, each upstream is sent a GET
+	// for the path and counts as up while it answers with a 2xx or 3xx
+	// status within HealthCheckTimeout, default 2 seconds.
+	HealthCheckPath     string
+	HealthCheckInterval time.Duration
+	HealthCheckTimeout  time.Duration
+
+	next atomic.Uint64
+
+	mu      sync.Mutex
+	ring    []ringPoint
+	ringFor []*Upstream
+	stop    chan struct{}
+}
+
+type ringPoint struct {
+	hash     uint32
+	upstream *Upstream
+}
+
+func NewBalancer(policy Policy, targets ...string) (*Balancer, error) {
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("proxy.NewBalancer: no upstreams")
+	}
+
+	b := &Balancer{Policy: policy}
+	for _, target := range targets {
+		u, err := url.Parse(target)
+		if err != nil {
+			return nil, fmt.Errorf("proxy.NewBalancer: %w", err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, fmt.Errorf(
+				"proxy.NewBalancer: unsupported scheme %q",
+				u.Scheme,
+			)
+		}
+		b.Upstreams = append(b.Upstreams, &Upstream{URL: u})
+	}
+
+	return b, nil
+}
+
+// hashRing returns the consistent hash ring for the current upstreams,
+// building it again whenever they have changed.
+func (b *Balancer) hashRing() []ringPoint {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.ring != nil && slices.Equal(b.ringFor, b.Upstreams) {
+		return b.ring
+	}
+
+	ring := make([]ringPoint, 0, len(b.Upstreams)*hashReplicas)
+	for _, upstream := range b.Upstreams {
+		for i := range hashReplicas {
+			key := upstream.URL.String() + "#" + strconv.Itoa(i)
+			ring = append(ring, ringPoint{
+				hash:     ringHash(key),
+				upstream: upstream,
+			})
+		}
+	}
+	slices.SortFunc(ring, func(a, b ringPoint) int {
+		return cmp.Compare(a.hash, b.hash)
+	})
+
+	b.ring = ring
+	b.ringFor = slices.Clone(b.Upstreams)
+	return ring
+}
+
+// startHealthChecks runs active health checks through transport in the
+// background until Close is called. It does nothing unless HealthCheckPath
+// is set or when the checks are already running.
+func (b *Balancer) startHealthChecks(transport client.RoundTripper) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.HealthCheckPath == "" || b.stop != nil {
+		return
+	}
+
+	stop := make(chan struct{})
+	b.stop = stop
+	go func() {
+		ticker := time.NewTicker(b.healthCheckInterval())
+		defer ticker.Stop()
+
+		b.checkAll(transport)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				b.checkAll(transport)
+			}
+		}
+	}()
+}
+
+// Close stops the health checks started by ReverseProxy.StartHealthChecks.
+func (b *Balancer) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.stop != nil {
+		close(b.stop)
+		b.stop = nil
+	}
+}
+
+// checkAll checks every upstream at once, so that one slow to answer does
+// not hold up the others.
+func (b *Balancer) checkAll(transport client.RoundTripper) {
+	c := &client.Client{
+		Transport: transport,
+		Timeout:   b.healthCheckTimeout(),
+	}
+
+	var wg sync.WaitGroup
+	for _, upstream := range b.Upstreams {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.check(c, upstream)
+		}()
+	}
+	wg.Wait()
+}
+
+func (b *Balancer) check(c *client.Client, upstream *Upstream) {
+	u := *upstream.URL
+	u.Path = joinPath(upstream.URL.Path, b.HealthCheckPath)
+	u.RawQuery = ""
+
+	healthy := false
+	req, err := client.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return
+	}
+	// The transport may be a cache, which must not answer for an upstream
+	// that has gone away.
+	req.Headers.Set("Cache-Control", "no-cache")
+	resp, err := c.Do(req)
+	if err == nil {
+		_, _ = io.Copy(io.Discard, resp.BodyReader)
+		_ = resp.BodyReader.Close()
+		code := resp.StatusLine.StatusCode
+		healthy = code >= response.OK && code < response.BADREQUEST
+	}
+
+	upstream.mu.Lock()
+	upstream.down = !healthy
+	upstream.mu.Unlock()
+}
+
+// forward sends req to upstreams picked by the policy until one answers,
+// retrying on another upstream only when that is safe.
+func (b *Balancer) forward(
+	p *ReverseProxy,
+	req *request.Request,
+) (*response.Response, error) {
+	turn := b.next.Add(1) - 1
+	tried := make(map[*Upstream]bool)
+	for {
+		upstream := b.pick(req, turn, tried)
+		if upstream == nil {
+			return nil, fmt.Errorf("Balancer.forward: %w", ErrNoUpstream)
+		}
+		tried[upstream] = true
+
+		outReq, err := p.outgoingRequest(req, upstream.URL)
+		if err != nil {
+			return nil, fmt.Errorf("Balancer.forward: %w", err)
+		}
+
+		upstream.active.Add(1)
+		resp, err := p.transport().RoundTrip(outReq)
+		if err != nil {
+			upstream.active.Add(-1)
+			b.reportFailure(upstream)
+			if retryable(req, outReq) && len(tried) < len(b.Upstreams) {
+				p.logger().Printf("Balancer.forward: retrying: %s\n", err)
+				continue
+			}
+			return nil, fmt.Errorf("Balancer.forward: %w", err)
+		}
+		b.reportSuccess(upstream)
+
+		resp.BodyReader = &releasingBody{
+			ReadCloser: resp.BodyReader,
+			upstream:   upstream,
+		}
+		return resp, nil
+	}
+}
+
+func retryable(req *request.Request, outReq *client.Request) bool {
+	switch req.RequestLine.Method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+	default:
+		return false
+	}
+
+	return outReq.Body == nil || len(req.Body) > 0
+}
+
+// pick returns an available upstream not in tried, or nil if there is
+// none. Round robin starts looking at the upstream whose turn it is, so
+// retries of one request do not skip anyone's turn for later requests.
+func (b *Balancer) pick(
+	req *request.Request,
+	turn uint64,
+	tried map[*Upstream]bool,
+) *Upstream {
+	now := time.Now()
+	usable := func(u *Upstream) bool {
+		return !tried[u] && u.available(now)
+	}
+
+	switch b.Policy {
+	case LeastConnections:
+		var best *Upstream
+		for _, upstream := range b.Upstreams {
+			if !usable(upstream) {
+				continue
+			}
+			if best == nil || upstream.active.Load() < best.active.Load() {
+				best = upstream
+			}
+		}
+		return best
+	case ConsistentHash:
+		ring := b.hashRing()
+		hash := ringHash(b.hashKey(req))
+		start, _ := slices.BinarySearchFunc(
+			ring,
+			hash,
+			func(point ringPoint, hash uint32) int {
+				return cmp.Compare(point.hash, hash)
+			},
+		)
+		for i := range ring {
+			upstream := ring[(start+i)%len(ring)].upstream
+			if usable(upstream) {
+				return upstream
+			}
+		}
+		return nil
+	default:
+		for i := range b.Upstreams {
+			upstream := b.Upstreams[(int(turn)+i)%len(b.Upstreams)]
+			if usable(upstream) {
+				return upstream
+			}
+		}
+		return nil
+	}
+}
+
+// ringHash places keys on the consistent hash ring. A cryptographic hash
+// keeps points for similar strings, like one upstream's replicas, from
+// clustering.
+func ringHash(key string) uint32 {
+	sum := sha256.Sum256([]byte(key))
+	return binary.BigEndian.Uint32(sum[:4])
+}
+
+func (b *Balancer) hashKey(req *request.Request) string {
+	if b.HashHeader != "" {
+		key, ok := req.Headers.Get(b.HashHeader)
+		if ok && key != "" {
+			return key
+		}
+	}
+
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+func (b *Balancer) reportFailure(upstream *Upstream) {
+	upstream.mu.Lock()
+	defer upstream.mu.Unlock()
+
+	upstream.failures++
+	if upstream.failures >= b.maxFails() {
+		upstream.failures = 0
+		upstream.ejectedUntil = time.Now().Add(b.ejectDuration())
+	}
+}
+
+func (b *Balancer) reportSuccess(upstream *Upstream) {
+	upstream.mu.Lock()
+	defer upstream.mu.Unlock()
+
+	upstream.failures = 0
+}
+
+func (u *Upstream) available(now time.Time) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return !u.down && !now.Before(u.ejectedUntil)
+}
+
+// ActiveRequests returns the number of requests to u whose response body
+// is still being relayed.
+func (u *Upstream) ActiveRequests() int64 {
+	return u.active.Load()
+}
+
+func (b *Balancer) maxFails() int {
+	if b.MaxFails == 0 {
+		return defaultMaxFails
+	}
+	return b.MaxFails
+}
+
+func (b *Balancer) ejectDuration() time.Duration {
+	if b.EjectDuration == 0 {
+		return defaultEjectDuration
+	}
+	return b.EjectDuration
+}
+
+func (b *Balancer) healthCheckInterval() time.Duration {
+	if b.HealthCheckInterval == 0 {
+		return defaultHealthCheckInterval
+	}
+	return b.HealthCheckInterval
+}
+
+func (b *Balancer) healthCheckTimeout() time.Duration {
+	if b.HealthCheckTimeout == 0 {
+		return defaultHealthCheckTimeout
+	}
+	return b.HealthCheckTimeout
+}
+
+// releasingBody ends an upstream's active request when the response body
+// is closed.
+type releasingBody struct {
+	io.ReadCloser
+	upstream *Upstream
+	released bool
+}
+
+func (rb *releasingBody) Close() error {
+	if !rb.released {
+		rb.released = true
+		rb.upstream.active.Add(-1)
+	}
+
+	return rb.ReadCloser.Close()
+}
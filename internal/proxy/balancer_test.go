@@ -0,0 +1,273 @@
+package proxy
+
+import (
+	"net"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/davidw1457/httpfromtcp/internal/cache"
+	"github.com/davidw1457/httpfromtcp/internal/client"
+	"github.com/davidw1457/httpfromtcp/internal/request"
+	"github.com/davidw1457/httpfromtcp/internal/response"
+	"github.com/davidw1457/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoundRobin(t *testing.T) {
+	front, _ := startBalanced(t, RoundRobin, "a", "b", "c")
+
+	// Test: Requests rotate through the upstreams in order
+	var got []string
+	for range 6 {
+		got = append(got, balancedGet(t, front, nil))
+	}
+	assert.Equal(t, []string{"a", "b", "c", "a", "b", "c"}, got)
+}
+
+func TestLeastConnections(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	slow := startServer(t, func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/slow" {
+			close(started)
+			<-release
+		}
+		writeName(w, "slow")
+	})
+	fast := startServer(t, named("fast"))
+
+	b, err := NewBalancer(LeastConnections, "http://"+slow, "http://"+fast)
+	require.NoError(t, err)
+	front := startServer(t, NewBalanced(b).Serve)
+
+	done := make(chan string)
+	go func() {
+		resp, err := client.Get("http://" + front + "/slow")
+		if err != nil {
+			done <- err.Error()
+			return
+		}
+		done <- readBody(t, resp)
+	}()
+	<-started
+	assert.Equal(t, int64(1), b.Upstreams[0].ActiveRequests())
+
+	// Test: Busy upstream is avoided while its request is in flight
+	for range 3 {
+		assert.Equal(t, "fast", balancedGet(t, front, nil))
+	}
+
+	close(release)
+	assert.Equal(t, "slow", <-done)
+	assert.Eventually(t, func() bool {
+		return b.Upstreams[0].ActiveRequests() == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestConsistentHash(t *testing.T) {
+	front, b := startBalanced(t, ConsistentHash, "a", "b", "c")
+	b.HashHeader = "X-User"
+
+	// Test: Same key always reaches the same upstream
+	first := balancedGet(t, front, map[string]string{"X-User": "alice"})
+	for range 5 {
+		got := balancedGet(t, front, map[string]string{"X-User": "alice"})
+		assert.Equal(t, first, got)
+	}
+
+	// Test: Different keys spread over the upstreams
+	seen := make(map[string]bool)
+	for i := range 30 {
+		user := "user-" + strconv.Itoa(i)
+		seen[balancedGet(t, front, map[string]string{"X-User": user})] = true
+	}
+	assert.Len(t, seen, 3)
+
+	// Test: Without the header, the client IP is the key
+	first = balancedGet(t, front, nil)
+	assert.Equal(t, first, balancedGet(t, front, nil))
+}
+
+func TestBalancerLiteral(t *testing.T) {
+	a, err := url.Parse("http://" + startServer(t, named("a")))
+	require.NoError(t, err)
+	b := &Balancer{
+		Policy:     ConsistentHash,
+		HashHeader: "X-User",
+		Upstreams:  []*Upstream{{URL: a}},
+	}
+	front := startServer(t, NewBalanced(b).Serve)
+
+	// Test: Balancer built as a literal serves its upstreams
+	assert.Equal(t, "a", balancedGet(t, front, nil))
+
+	// Test: Upstream added later joins the hash ring
+	bURL, err := url.Parse("http://" + startServer(t, named("b")))
+	require.NoError(t, err)
+	b.Upstreams = append(b.Upstreams, &Upstream{URL: bURL})
+	seen := make(map[string]bool)
+	for i := range 30 {
+		user := "user-" + strconv.Itoa(i)
+		seen[balancedGet(t, front, map[string]string{"X-User": user})] = true
+	}
+	assert.Len(t, seen, 2)
+}
+
+func TestPassiveEjectionAndRetry(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	dead := ln.Addr().String()
+	ln.Close()
+	live := startServer(t, named("live"))
+
+	b, err := NewBalancer(RoundRobin, "http://"+dead, "http://"+live)
+	require.NoError(t, err)
+	b.MaxFails = 2
+	front := startServer(t, NewBalanced(b).Serve)
+
+	// Test: Idempotent request is retried on another upstream
+	assert.Equal(t, "live", balancedGet(t, front, nil))
+
+	// Test: Non-idempotent request is not retried
+	assert.Equal(t, response.OK, post(t, front))
+	assert.Equal(t, response.BADGATEWAY, post(t, front))
+
+	// Test: Upstream is ejected after consecutive failures
+	for range 4 {
+		assert.Equal(t, response.OK, post(t, front))
+	}
+}
+
+func TestActiveHealthCheck(t *testing.T) {
+	var down atomic.Bool
+	flaky := startServer(t, func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/health" && down.Load() {
+			writeUnavailable(w)
+			return
+		}
+		writeName(w, "flaky")
+	})
+	stable := startServer(t, named("stable"))
+
+	b, err := NewBalancer(RoundRobin, "http://"+flaky, "http://"+stable)
+	require.NoError(t, err)
+	b.HealthCheckPath = "/health"
+	b.HealthCheckInterval = 10 * time.Millisecond
+	p := NewBalanced(b)
+	p.StartHealthChecks()
+	t.Cleanup(b.Close)
+	front := startServer(t, p.Serve)
+
+	// Test: Upstream failing its health check gets no traffic
+	down.Store(true)
+	assert.Eventually(t, func() bool {
+		return !b.Upstreams[0].available(time.Now())
+	}, time.Second, 5*time.Millisecond)
+	for range 4 {
+		assert.Equal(t, "stable", balancedGet(t, front, nil))
+	}
+
+	// Test: Upstream comes back once it passes again
+	down.Store(false)
+	assert.Eventually(t, func() bool {
+		return b.Upstreams[0].available(time.Now())
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestHealthCheckThroughCache(t *testing.T) {
+	upstream := &server.Server{
+		Addr: "127.0.0.1:0",
+		Handler: func(w *response.Writer, req *request.Request) {
+			h := response.GetDefaultHeaders(2)
+			h.Set("Cache-Control", "max-age=3600")
+			_ = w.WriteStatusLine(response.OK)
+			_ = w.WriteHeaders(h)
+			_, _ = w.WriteBody([]byte("ok"))
+		},
+	}
+	require.NoError(t, upstream.Start())
+	t.Cleanup(func() { _ = upstream.Close() })
+
+	b, err := NewBalancer(RoundRobin, "http://"+upstream.ListenAddr().String())
+	require.NoError(t, err)
+	b.HealthCheckPath = "/health"
+	b.HealthCheckInterval = 10 * time.Millisecond
+	p := NewBalanced(b)
+	p.Transport = cache.New(&client.Transport{}, 1<<20)
+	p.StartHealthChecks()
+	t.Cleanup(b.Close)
+
+	// Test: Stopped upstream is marked down despite a cached health check
+	time.Sleep(30 * time.Millisecond)
+	require.True(t, b.Upstreams[0].available(time.Now()))
+	require.NoError(t, upstream.Close())
+	assert.Eventually(t, func() bool {
+		return !b.Upstreams[0].available(time.Now())
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestNoUpstreamAvailable(t *testing.T) {
+	front, b := startBalanced(t, RoundRobin, "a")
+	b.Upstreams[0].down = true
+
+	// Test: Every upstream down is a 503
+	resp, err := client.Get("http://" + front + "/")
+	require.NoError(t, err)
+	readBody(t, resp)
+	assert.Equal(t, response.SERVICEUNAVAILABLE, resp.StatusLine.StatusCode)
+}
+
+func startBalanced(t *testing.T, policy Policy, names ...string) (string, *Balancer) {
+	t.Helper()
+	var targets []string
+	for _, name := range names {
+		targets = append(targets, "http://"+startServer(t, named(name)))
+	}
+
+	b, err := NewBalancer(policy, targets...)
+	require.NoError(t, err)
+	return startServer(t, NewBalanced(b).Serve), b
+}
+
+func balancedGet(t *testing.T, front string, h map[string]string) string {
+	t.Helper()
+	req, err := client.NewRequest("GET", "http://"+front+"/", nil)
+	require.NoError(t, err)
+	for k, v := range h {
+		req.Headers.Set(k, v)
+	}
+	resp, err := client.DefaultClient.Do(req)
+	require.NoError(t, err)
+	return readBody(t, resp)
+}
+
+func post(t *testing.T, front string) response.StatusCode {
+	t.Helper()
+	req, err := client.NewRequest("POST", "http://"+front+"/", nil)
+	require.NoError(t, err)
+	resp, err := client.DefaultClient.Do(req)
+	require.NoError(t, err)
+	readBody(t, resp)
+	return resp.StatusLine.StatusCode
+}
+
+func named(name string) func(w *response.Writer, req *request.Request) {
+	return func(w *response.Writer, req *request.Request) {
+		writeName(w, name)
+	}
+}
+
+func writeName(w *response.Writer, name string) {
+	_ = w.WriteStatusLine(response.OK)
+	_ = w.WriteHeaders(response.GetDefaultHeaders(len(name)))
+	_, _ = w.WriteBody([]byte(name))
+}
+
+func writeUnavailable(w *response.Writer) {
+	_ = w.WriteStatusLine(response.SERVICEUNAVAILABLE)
+	_ = w.WriteHeaders(response.GetDefaultHeaders(0))
+}
@@ -0,0 +1,388 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/davidw1457/httpfromtcp/internal/client"
+	"github.com/davidw1457/httpfromtcp/internal/headers"
+	"github.com/davidw1457/httpfromtcp/internal/request"
+	"github.com/davidw1457/httpfromtcp/internal/response"
+)
+
+const bufferSize = 32 * 1024
+
+// hopByHopHeaders apply to a single connection and are never forwarded,
+// along with any field named in the Connection header.
+var hopByHopHeaders = []string{
+	"connection",
+	"keep-alive",
+	"proxy-authenticate",
+	"proxy-authorization",
+	"proxy-connection",
+	"te",
+	"transfer-encoding",
+	"upgrade",
+}
+
+var errBadRequest = errors.New("bad request")
+
+// ReverseProxy forwards requests to an upstream server and relays its
+// responses. Its Serve method is a server.Handler.
+//
+// The upstream URL is Target with the request path, minus StripPrefix,
+// appended to its path and the request query merged into its query. When
+// Balancer is set, it picks the target for each request instead.
+// Responses keep the upstream status, reason phrase and header fields;
+// bodies without a Content-Length are relayed chunked, trailers included.
+type ReverseProxy struct {
+	Target      *url.URL
+	Balancer    *Balancer
+	StripPrefix string
+	// Transport defaults to client.DefaultTransport.
+	Transport client.RoundTripper
+	// Logger defaults to the standard logger.
+	Logger *log.Logger
+}
+
+func New(target string) (*ReverseProxy, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("proxy.New: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("proxy.New: unsupported scheme %q", u.Scheme)
+	}
+
+	return &ReverseProxy{Target: u}, nil
+}
+
+// NewBalanced returns a proxy spreading requests over the upstreams of b.
+func NewBalanced(b *Balancer) *ReverseProxy {
+	return &ReverseProxy{Balancer: b}
+}
+
+// StartHealthChecks runs the active health checks of Balancer in the
+// background, sending them through Transport with Cache-Control: no-cache,
+// until Balancer.Close is called. It does nothing without a Balancer or a
+// HealthCheckPath.
+func (p *ReverseProxy) StartHealthChecks() {
+	if p.Balancer != nil {
+		p.Balancer.startHealthChecks(p.transport())
+	}
+}
+
+func (p *ReverseProxy) Serve(w *response.Writer, req *request.Request) {
+	resp, err := p.forward(req)
+	if err != nil {
+		p.logger().Printf("ReverseProxy.Serve: %s\n", err)
+		switch {
+		case errors.Is(err, errBadRequest):
+			p.writeStatus(w, response.BADREQUEST)
+		case errors.Is(err, ErrNoUpstream):
+			p.writeStatus(w, response.SERVICEUNAVAILABLE)
+		case errors.Is(err, os.ErrDeadlineExceeded):
+			p.writeStatus(w, response.GATEWAYTIMEOUT)
+		default:
+			p.writeStatus(w, response.BADGATEWAY)
+		}
+		return
+	}
+	defer resp.BodyReader.Close()
+
+	err = p.relayResponse(w, req, resp)
+	if err != nil {
+		p.logger().Printf("ReverseProxy.Serve: %s\n", err)
+	}
+}
+
+func (p *ReverseProxy) forward(req *request.Request) (*response.Response, error) {
+	if p.Balancer != nil {
+		return p.Balancer.forward(p, req)
+	}
+
+	outReq, err := p.outgoingRequest(req, p.Target)
+	if err != nil {
+		return nil, fmt.Errorf("forward: %w", err)
+	}
+
+	resp, err := p.transport().RoundTrip(outReq)
+	if err != nil {
+		return nil, fmt.Errorf("forward: %w", err)
+	}
+
+	return resp, nil
+}
+
+// outgoingRequest builds the request sent to upstream. A body the server
+// already buffered in req.Body is sent from a fresh reader, so the request
+// can be built again for a retry.
+func (p *ReverseProxy) outgoingRequest(
+	req *request.Request,
+	upstream *url.URL,
+) (*client.Request, error) {
+	target, err := url.Parse(req.RequestLine.RequestTarget)
+	if err != nil {
+		return nil, fmt.Errorf("outgoingRequest: %w: %w", errBadRequest, err)
+	}
+
+	// The path is joined in its escaped form so that an encoded slash or
+	// other reserved byte reaches the upstream as the client sent it.
+	rawPath := joinPath(
+		upstream.EscapedPath(),
+		strings.TrimPrefix(target.EscapedPath(), p.StripPrefix),
+	)
+	path, err := url.PathUnescape(rawPath)
+	if err != nil {
+		return nil, fmt.Errorf("outgoingRequest: %w: %w", errBadRequest, err)
+	}
+
+	u := *upstream
+	u.Path = path
+	u.RawPath = rawPath
+	switch {
+	case upstream.RawQuery == "":
+		u.RawQuery = target.RawQuery
+	case target.RawQuery != "":
+		u.RawQuery = upstream.RawQuery + "&" + target.RawQuery
+	}
+
+	h := req.Headers.Clone()
+	removeHopByHop(h)
+	h.Delete("Host")
+	h.Delete("Content-Length")
+
+	host, _ := req.Headers.Get("Host")
+	proto := "http"
+	if req.TLS != nil {
+		proto = "https"
+	}
+	addForwarded(h, req.RemoteAddr, host, proto)
+
+	outReq := &client.Request{
+		Method:        req.RequestLine.Method,
+		URL:           &u,
+		Headers:       h,
+		ContentLength: 0,
+	}
+
+	var body io.Reader = req.BodyReader
+	if len(req.Body) > 0 {
+		body = bytes.NewReader(req.Body)
+	}
+
+	switch {
+	case req.Headers.ContainsToken("Transfer-Encoding", "chunked"):
+		outReq.Body = body
+		outReq.ContentLength = -1
+	default:
+		n, ok, err := req.Headers.Int("Content-Length")
+		if !ok {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("outgoingRequest: %w: %w", errBadRequest, err)
+		}
+		if n > 0 {
+			outReq.Body = body
+			outReq.ContentLength = n
+		}
+	}
+
+	return outReq, nil
+}
+
+// addForwarded appends the client to X-Forwarded-For and Forwarded, and
+// records the host and protocol it asked for.
+func addForwarded(h headers.Headers, remoteAddr string, host string, proto string) {
+	clientIP, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		clientIP = remoteAddr
+	}
+	if clientIP == "" {
+		return
+	}
+
+	h.Add("X-Forwarded-For", clientIP)
+	if host != "" {
+		h.Set("X-Forwarded-Host", host)
+	}
+	h.Set("X-Forwarded-Proto", proto)
+
+	node := clientIP
+	if strings.Contains(clientIP, ":") {
+		node = `"[` + clientIP + `]"`
+	}
+	forwarded := "for=" + node
+	if host != "" {
+		forwarded += ";host=" + strconv.Quote(host)
+	}
+	forwarded += ";proto=" + proto
+	h.Add("Forwarded", forwarded)
+}
+
+func (p *ReverseProxy) relayResponse(
+	w *response.Writer,
+	req *request.Request,
+	resp *response.Response,
+) error {
+	h := resp.Headers.Clone()
+	removeHopByHop(h)
+
+	statusCode := resp.StatusLine.StatusCode
+	hasBody := req.RequestLine.Method != "HEAD" &&
+		statusCode != response.NOCONTENT &&
+		statusCode != response.NOTMODIFIED
+	_, hasLength := h.Get("Content-Length")
+	chunked := hasBody && !hasLength
+	if chunked {
+		h.Set("Transfer-Encoding", "chunked")
+	}
+
+	err := w.WriteStatusLineWithReason(statusCode, resp.StatusLine.Reason)
+	if err != nil {
+		return fmt.Errorf("relayResponse: %w", err)
+	}
+
+	err = w.WriteHeaders(h)
+	if err != nil {
+		return fmt.Errorf("relayResponse: %w", err)
+	}
+
+	if !hasBody {
+		return nil
+	}
+
+	if !chunked {
+		_, err = io.CopyBuffer(bodyWriter{w}, resp.BodyReader, make([]byte, bufferSize))
+		if err != nil {
+			return fmt.Errorf("relayResponse: %w", err)
+		}
+		return nil
+	}
+
+	_, err = io.CopyBuffer(chunkWriter{w}, resp.BodyReader, make([]byte, bufferSize))
+	if err != nil {
+		return fmt.Errorf("relayResponse: %w", err)
+	}
+
+	_, err = w.WriteChunkedBodyDone()
+	if err != nil {
+		return fmt.Errorf("relayResponse: %w", err)
+	}
+
+	err = w.WriteTrailers(trailerFields(resp.Trailers))
+	if err != nil {
+		return fmt.Errorf("relayResponse: %w", err)
+	}
+
+	return nil
+}
+
+// trailerFields arranges trailers the way Writer.WriteTrailers expects them:
+// the values plus a Trailer field naming each one.
+func trailerFields(trailers headers.Headers) headers.Headers {
+	h := trailers.Clone()
+	names := make([]string, 0, len(trailers))
+	for k := range trailers.All() {
+		names = append(names, k)
+	}
+	h.Set("Trailer", strings.Join(names, ", "))
+
+	return h
+}
+
+func removeHopByHop(h headers.Headers) {
+	for _, name := range h.List("Connection") {
+		h.Delete(name)
+	}
+
+	for _, name := range hopByHopHeaders {
+		h.Delete(name)
+	}
+}
+
+func joinPath(base string, path string) string {
+	switch {
+	case path == "":
+		if base == "" {
+			return "/"
+		}
+		return base
+	case strings.HasSuffix(base, "/") && strings.HasPrefix(path, "/"):
+		return base + path[1:]
+	case !strings.HasSuffix(base, "/") && !strings.HasPrefix(path, "/"):
+		return base + "/" + path
+	}
+	return base + path
+}
+
+func (p *ReverseProxy) transport() client.RoundTripper {
+	if p.Transport != nil {
+		return p.Transport
+	}
+	return client.DefaultTransport
+}
+
+func (p *ReverseProxy) logger() *log.Logger {
+	if p.Logger != nil {
+		return p.Logger
+	}
+	return log.Default()
+}
+
+type bodyWriter struct {
+	w *response.Writer
+}
+
+func (bw bodyWriter) Write(p []byte) (int, error) {
+	return bw.w.WriteBody(p)
+}
+
+type chunkWriter struct {
+	w *response.Writer
+}
+
+func (cw chunkWriter) Write(p []byte) (int, error) {
+	_, err := cw.w.WriteChunkedBody(p)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (p *ReverseProxy) writeStatus(
+	w *response.Writer,
+	statusCode response.StatusCode,
+) {
+	body := []byte(fmt.Sprintf(
+		"%d %s\n",
+		statusCode,
+		response.StatusText(statusCode),
+	))
+
+	err := w.WriteStatusLine(statusCode)
+	if err != nil {
+		p.logger().Printf("ReverseProxy.writeStatus: %s\n", err)
+		return
+	}
+
+	err = w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	if err != nil {
+		p.logger().Printf("ReverseProxy.writeStatus: %s\n", err)
+		return
+	}
+
+	_, err = w.WriteBody(body)
+	if err != nil {
+		p.logger().Printf("ReverseProxy.writeStatus: %s\n", err)
+	}
+}
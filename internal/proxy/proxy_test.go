@@ -0,0 +1,149 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/davidw1457/httpfromtcp/internal/client"
+	"github.com/davidw1457/httpfromtcp/internal/headers"
+	"github.com/davidw1457/httpfromtcp/internal/request"
+	"github.com/davidw1457/httpfromtcp/internal/response"
+	"github.com/davidw1457/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReverseProxyForwarding(t *testing.T) {
+	var upstreamReq *request.Request
+	upstream := startServer(t, func(w *response.Writer, req *request.Request) {
+		upstreamReq = req
+		body := []byte("created " + string(req.Body))
+		h := response.GetDefaultHeaders(len(body))
+		h.Set("X-Upstream", "yes")
+		h.Set("Keep-Alive", "timeout=5")
+		_ = w.WriteStatusLineWithReason(response.CREATED, "Made It")
+		_ = w.WriteHeaders(h)
+		_, _ = w.WriteBody(body)
+	})
+
+	p, err := New("http://" + upstream + "/base?fixed=1")
+	require.NoError(t, err)
+	p.StripPrefix = "/api"
+	front := startServer(t, p.Serve)
+
+	req, err := client.NewRequest(
+		"POST",
+		"http://"+front+"/api/items?page=2",
+		strings.NewReader("widget"),
+	)
+	require.NoError(t, err)
+	req.Headers.Set("Connection", "X-Secret")
+	req.Headers.Set("X-Secret", "hop")
+	req.Headers.Set("X-Forwarded-For", "203.0.113.9")
+	req.Headers.Set("X-Custom", "kept")
+	resp, err := client.DefaultClient.Do(req)
+	require.NoError(t, err)
+	body := readBody(t, resp)
+
+	// Test: Method, target, body and end-to-end headers reach the upstream
+	require.NotNil(t, upstreamReq)
+	assert.Equal(t, "POST", upstreamReq.RequestLine.Method)
+	assert.Equal(t, "/base/items?fixed=1&page=2", upstreamReq.RequestLine.RequestTarget)
+	assert.Equal(t, "widget", string(upstreamReq.Body))
+	custom, _ := upstreamReq.Headers.Get("X-Custom")
+	assert.Equal(t, "kept", custom)
+	host, _ := upstreamReq.Headers.Get("Host")
+	assert.Equal(t, upstream, host)
+
+	// Test: Hop-by-hop headers are stripped
+	_, ok := upstreamReq.Headers.Get("X-Secret")
+	assert.False(t, ok)
+
+	// Test: Client is appended to X-Forwarded-For and Forwarded
+	xff, _ := upstreamReq.Headers.Get("X-Forwarded-For")
+	assert.Equal(t, "203.0.113.9, 127.0.0.1", xff)
+	forwarded, _ := upstreamReq.Headers.Get("Forwarded")
+	assert.Equal(t, fmt.Sprintf("for=127.0.0.1;host=%q;proto=http", front), forwarded)
+
+	// Test: Real status, reason and headers are relayed
+	assert.Equal(t, response.CREATED, resp.StatusLine.StatusCode)
+	assert.Equal(t, "Made It", resp.StatusLine.Reason)
+	upstreamHeader, _ := resp.Headers.Get("X-Upstream")
+	assert.Equal(t, "yes", upstreamHeader)
+	_, ok = resp.Headers.Get("Keep-Alive")
+	assert.False(t, ok)
+	assert.Equal(t, "created widget", body)
+
+	// Test: Encoded slash in the path is not decoded on the way
+	resp, err = client.Get("http://" + front + "/api/a%2Fb")
+	require.NoError(t, err)
+	readBody(t, resp)
+	assert.Equal(t, "/base/a%2Fb?fixed=1", upstreamReq.RequestLine.RequestTarget)
+}
+
+func TestReverseProxyStreaming(t *testing.T) {
+	upstream := startServer(t, func(w *response.Writer, req *request.Request) {
+		h := headers.NewHeaders()
+		h.Set("Transfer-Encoding", "chunked")
+		h.Set("Trailer", "X-Checksum")
+		_ = w.WriteStatusLine(response.OK)
+		_ = w.WriteHeaders(h)
+		_, _ = w.WriteChunkedBody([]byte("streamed "))
+		_, _ = w.WriteChunkedBody([]byte("body"))
+		_, _ = w.WriteChunkedBodyDone()
+		h.Set("X-Checksum", "abc123")
+		_ = w.WriteTrailers(h)
+	})
+
+	p, err := New("http://" + upstream)
+	require.NoError(t, err)
+	front := startServer(t, p.Serve)
+
+	// Test: Chunked response is relayed chunked with its trailers
+	resp, err := client.Get("http://" + front + "/stream")
+	require.NoError(t, err)
+	assert.True(t, resp.Headers.ContainsToken("Transfer-Encoding", "chunked"))
+	assert.Equal(t, "streamed body", readBody(t, resp))
+	checksum, _ := resp.Trailers.Get("X-Checksum")
+	assert.Equal(t, "abc123", checksum)
+}
+
+func TestReverseProxyUpstreamDown(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	ln.Close()
+
+	p, err := New("http://" + addr)
+	require.NoError(t, err)
+	front := startServer(t, p.Serve)
+
+	// Test: Unreachable upstream is a 502
+	resp, err := client.Get("http://" + front + "/")
+	require.NoError(t, err)
+	readBody(t, resp)
+	assert.Equal(t, response.BADGATEWAY, resp.StatusLine.StatusCode)
+
+	// Test: Invalid target URL
+	_, err = New("ftp://example.com")
+	assert.Error(t, err)
+}
+
+func startServer(t *testing.T, handler server.Handler) string {
+	t.Helper()
+	s := &server.Server{Addr: "127.0.0.1:0", Handler: handler}
+	require.NoError(t, s.Start())
+	t.Cleanup(func() { _ = s.Close() })
+	return s.ListenAddr().String()
+}
+
+func readBody(t *testing.T, resp *response.Response) string {
+	t.Helper()
+	defer resp.BodyReader.Close()
+	body, err := io.ReadAll(resp.BodyReader)
+	require.NoError(t, err)
+	return string(body)
+}
@@ -0,0 +1,16 @@
+package request
+
+// bodySource lets a streamed body pull the rest of its request through the
+// reader it came from.
+type bodySource struct {
+	reader  *Reader
+	request *Request
+}
+
+func (bs *bodySource) Parse() error {
+	return bs.reader.parseBuffered(bs.request)
+}
+
+func (bs *bodySource) ReadMore() error {
+	return bs.reader.readMore()
+}
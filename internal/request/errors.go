@@ -0,0 +1,20 @@
+package request
+
+import (
+	"errors"
+
+	"github.com/davidw1457/httpfromtcp/internal/body"
+)
+
+// Errors returned while parsing a request. They are wrapped with context,
+// so match them with errors.Is.
+var (
+	ErrMalformedRequestLine        = errors.New("malformed request line")
+	ErrUnsupportedVersion          = errors.New("unsupported http version")
+	ErrInvalidContentLength        = errors.New("invalid content-length")
+	ErrUnsupportedTransferEncoding = errors.New("unsupported transfer-encoding")
+	ErrMalformedChunk              = body.ErrMalformedChunk
+	ErrRequestLineTooLong          = errors.New("request line too long")
+	ErrHeadersTooLarge             = errors.New("request header fields too large")
+	ErrBodyTooLarge                = errors.New("request body too large")
+)
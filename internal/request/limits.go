@@ -0,0 +1,68 @@
+package request
+
+// Limits bounds the memory a single request may use while it is parsed.
+// A zero field disables that limit.
+type Limits struct {
+	MaxRequestLineLength int
+	MaxHeaderBytes       int
+	MaxHeaderCount       int
+	MaxBodySize          int
+}
+
+// DefaultLimits bounds the request line and header section. The body is
+// left unlimited; set MaxBodySize to cap it.
+var DefaultLimits = Limits{
+	MaxRequestLineLength: 8 * 1024,
+	MaxHeaderBytes:       64 * 1024,
+	MaxHeaderCount:       100,
+}
+
+func (r *Request) checkRequestLine(data []byte, n int) error {
+	limit := r.limits.MaxRequestLineLength
+	if limit == 0 {
+		return nil
+	}
+
+	if n == 0 && len(data) > limit {
+		return ErrRequestLineTooLong
+	}
+	if n-2 > limit {
+		return ErrRequestLineTooLong
+	}
+
+	return nil
+}
+
+func (r *Request) checkHeaders(data []byte, n int, done bool) error {
+	if n == 0 {
+		limit := r.limits.MaxHeaderBytes
+		if limit > 0 && r.headerBytesRead+len(data) > limit {
+			return ErrHeadersTooLarge
+		}
+		return nil
+	}
+
+	r.headerBytesRead += n
+	if !done {
+		r.headerCount++
+	}
+
+	if r.limits.MaxHeaderBytes > 0 &&
+		r.headerBytesRead > r.limits.MaxHeaderBytes {
+		return ErrHeadersTooLarge
+	}
+	if r.limits.MaxHeaderCount > 0 &&
+		r.headerCount > r.limits.MaxHeaderCount {
+		return ErrHeadersTooLarge
+	}
+
+	return nil
+}
+
+func (r *Request) checkBodySize(size int) error {
+	if r.limits.MaxBodySize > 0 && size > r.limits.MaxBodySize {
+		return ErrBodyTooLarge
+	}
+
+	return nil
+}
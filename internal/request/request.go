@@ -2,13 +2,15 @@ package request
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"io"
 	"regexp"
-	"strconv"
 	"strings"
 
+	"github.com/davidw1457/httpfromtcp/internal/body"
 	"github.com/davidw1457/httpfromtcp/internal/headers"
 )
 
@@ -18,9 +20,20 @@ type Request struct {
 	RequestLine RequestLine
 	Headers     headers.Headers
 	Body        []byte
+	BodyReader  io.ReadCloser
+	Trailers    headers.Headers
+	PathParams  map[string]string
+	RemoteAddr  string
+	// TLS is nil for plaintext connections.
+	TLS *tls.ConnectionState
 
-	state          requestState
-	bodyLengthRead int
+	state   requestState
+	decoder *body.Decoder
+
+	limits          Limits
+	obsFold         headers.ObsFoldPolicy
+	headerBytesRead int
+	headerCount     int
 }
 
 type RequestLine struct {
@@ -35,47 +48,139 @@ const (
 	requestStateInitialized requestState = iota
 	requestStateParsingHeaders
 	requestStateParsingBody
+	requestStateDecodingBody
 	requestStateDone
 )
 
+// Reader parses consecutive requests from a single connection. Bytes read
+// past the end of one request are kept for the next call to ReadRequest.
+//
+// When StreamBody is set, ReadRequest returns as soon as the headers are
+// parsed and the body is read through Request.BodyReader instead of being
+// collected into Request.Body. The BodyReader must be closed before the
+// next call to ReadRequest.
+//
+// Limits is applied to every request read; NewReader sets it to
+// DefaultLimits. ObsFold defaults to rejecting requests with obsolete line
+// folding.
+type Reader struct {
+	StreamBody bool
+	Limits     Limits
+	ObsFold    headers.ObsFoldPolicy
+
+	reader      io.Reader
+	buf         []byte
+	readToIndex int
+}
+
+func NewReader(reader io.Reader) *Reader {
+	return &Reader{
+		Limits: DefaultLimits,
+		reader: reader,
+		buf:    make([]byte, bufferSize),
+	}
+}
+
 func RequestFromReader(reader io.Reader) (*Request, error) {
+	return NewReader(reader).ReadRequest()
+}
+
+// ReadRequest returns io.EOF when the reader is exhausted before any byte
+// of a new request arrives.
+func (rr *Reader) ReadRequest() (*Request, error) {
 	request := Request{
-		Headers: headers.NewHeaders(),
-		Body:    make([]byte, 0),
-		state:   requestStateInitialized,
+		Headers:  headers.NewHeaders(),
+		Body:     make([]byte, 0),
+		Trailers: headers.NewHeaders(),
+		state:    requestStateInitialized,
+		limits:   rr.Limits,
+		obsFold:  rr.ObsFold,
 	}
-	buf := make([]byte, bufferSize)
-	readToIndex := 0
 
-	for request.state != requestStateDone {
-		if readToIndex >= len(buf) {
-			newBuf := make([]byte, len(buf)*2)
-			copy(newBuf, buf)
-			buf = newBuf
+	for {
+		err := rr.parseBuffered(&request)
+		if err != nil {
+			return nil, fmt.Errorf("RequestFromReader: %w", err)
 		}
 
-		n, err := reader.Read(buf[readToIndex:])
+		if request.state == requestStateDone {
+			break
+		}
+		if rr.StreamBody && request.state > requestStateParsingHeaders {
+			break
+		}
+
+		err = rr.readMore()
 		if err != nil {
 			if errors.Is(err, io.EOF) {
-				if request.state != requestStateDone {
-					return nil, fmt.Errorf("incomplete request")
+				if request.state == requestStateInitialized &&
+					rr.readToIndex == 0 {
+					return nil, io.EOF
 				}
-				break
+				return nil, fmt.Errorf("incomplete request")
 			}
 			return nil, fmt.Errorf("RequestFromReader: %w", err)
 		}
+	}
+
+	if rr.StreamBody {
+		request.BodyReader = body.NewReader(
+			&bodySource{reader: rr, request: &request},
+			request.decoder,
+		)
+	} else {
+		request.Body = append(request.Body, request.decoder.Bytes()...)
+		request.BodyReader = io.NopCloser(bytes.NewReader(request.Body))
+	}
+
+	return &request, nil
+}
 
-		readToIndex += n
-		n, err = request.parse(buf[:readToIndex])
+// Buffered returns the number of bytes read from the underlying reader
+// that have not been consumed by a request yet.
+func (rr *Reader) Buffered() int {
+	return rr.readToIndex
+}
+
+// Fill blocks until at least one byte is buffered, so callers can tell an
+// idle connection apart from one that has started sending a request.
+func (rr *Reader) Fill() error {
+	for rr.readToIndex == 0 {
+		err := rr.readMore()
 		if err != nil {
-			return nil, fmt.Errorf("RequestFromReader: %w", err)
+			return err
 		}
+	}
 
-		copy(buf, buf[n:])
-		readToIndex -= n
+	return nil
+}
+
+func (rr *Reader) parseBuffered(request *Request) error {
+	n, err := request.parse(rr.buf[:rr.readToIndex])
+	if err != nil {
+		return err
 	}
 
-	return &request, nil
+	copy(rr.buf, rr.buf[n:rr.readToIndex])
+	rr.readToIndex -= n
+
+	return nil
+}
+
+func (rr *Reader) readMore() error {
+	if rr.readToIndex >= len(rr.buf) {
+		newBuf := make([]byte, len(rr.buf)*2)
+		copy(newBuf, rr.buf)
+		rr.buf = newBuf
+	}
+
+	n, err := rr.reader.Read(rr.buf[rr.readToIndex:])
+	rr.readToIndex += n
+	if n > 0 && errors.Is(err, io.EOF) {
+		return nil
+	}
+
+	return err
 }
 
 func parseRequestLine(input []byte) (*RequestLine, int, error) {
@@ -97,24 +202,40 @@ func requestLineFromString(line string) (*RequestLine, error) {
 
 	if len(fields) != 3 {
 		return nil, fmt.Errorf(
-			"requestLineFromString: request-line missing fields %s",
+			"requestLineFromString: %w: missing fields %s",
+			ErrMalformedRequestLine,
 			line,
 		)
 	}
 
-	validMethod, err := regexp.Match("[A-Z]+$", []byte(fields[0]))
+	validMethod, err := regexp.Match("^[A-Z]+$", []byte(fields[0]))
 	if err != nil {
 		return nil, fmt.Errorf("parseRequestLine: %w", err)
 	} else if !validMethod {
 		return nil, fmt.Errorf(
-			"parseRequestLine: invalid method: %s",
+			"parseRequestLine: %w: invalid method: %s",
+			ErrMalformedRequestLine,
 			fields[0],
 		)
 	}
 
-	if !strings.Contains(fields[2], "HTTP/1.1") {
+	if fields[2] != "HTTP/1.1" {
+		validVersion, err := regexp.MatchString(
+			"^HTTP/[0-9]\\.[0-9]$",
+			fields[2],
+		)
+		if err != nil {
+			return nil, fmt.Errorf("parseRequestLine: %w", err)
+		} else if validVersion {
+			return nil, fmt.Errorf(
+				"parseRequestLine: %w: %s",
+				ErrUnsupportedVersion,
+				fields[2],
+			)
+		}
 		return nil, fmt.Errorf(
-			"parseRequestLine: invalid http version: %s",
+			"parseRequestLine: %w: invalid http version: %s",
+			ErrMalformedRequestLine,
 			fields[2],
 		)
 	}
@@ -130,12 +251,13 @@ func requestLineFromString(line string) (*RequestLine, error) {
 func (r *Request) parse(data []byte) (int, error) {
 	bytesParsed := 0
 	for r.state != requestStateDone {
+		state := r.state
 		n, err := r.parseSingle(data[bytesParsed:])
 		if err != nil {
 			return n, fmt.Errorf("request.parse: %w", err)
 		}
 		bytesParsed += n
-		if n == 0 {
+		if n == 0 && r.state == state {
 			break
 		}
 	}
@@ -147,11 +269,22 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 	case requestStateDone:
 		return 0, fmt.Errorf("error: trying to read data in a done state")
 	case requestStateInitialized:
+		// Empty lines before the request line are ignored (RFC 9112
+		// section 2.2), such as a CRLF some clients send after a body.
+		if bytes.HasPrefix(data, []byte("\r\n")) {
+			return 2, nil
+		}
+
 		requestLine, n, err := parseRequestLine(data)
 		if err != nil {
 			return 0, fmt.Errorf("request.parse: %w", err)
 		}
 
+		err = r.checkRequestLine(data, n)
+		if err != nil {
+			return 0, fmt.Errorf("request.parse: %w", err)
+		}
+
 		if n == 0 {
 			return 0, nil
 		}
@@ -161,7 +294,12 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 
 		return n, nil
 	case requestStateParsingHeaders:
-		n, done, err := r.Headers.Parse(data)
+		n, done, err := r.Headers.ParseFolded(data, r.obsFold)
+		if err != nil {
+			return 0, fmt.Errorf("request.parse: %w", err)
+		}
+
+		err = r.checkHeaders(data, n, done)
 		if err != nil {
 			return 0, fmt.Errorf("request.parse: %w", err)
 		}
@@ -171,26 +309,76 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 		}
 		return n, nil
 	case requestStateParsingBody:
-		contentLengthStr, ok := r.Headers.Get("Content-Length")
-		if !ok {
-			r.state = requestStateDone
-			return len(data), nil
+		transferEncoding, ok := r.Headers.Get("Transfer-Encoding")
+		if ok {
+			// A request framed both ways is how requests are smuggled past
+			// an intermediary that picks the other length (RFC 9112
+			// section 6.1), so it is refused rather than guessed at.
+			if _, framed := r.Headers.Get("Content-Length"); framed {
+				return 0, fmt.Errorf(
+					"request.parse: %w: sent with transfer-encoding",
+					ErrInvalidContentLength,
+				)
+			}
+			if !strings.EqualFold(strings.TrimSpace(transferEncoding), "chunked") {
+				return 0, fmt.Errorf(
+					"request.parse: %w: %s",
+					ErrUnsupportedTransferEncoding,
+					transferEncoding,
+				)
+			}
+			r.decoder = body.NewChunkedDecoder(r.Trailers)
+			r.decoder.ObsFold = r.obsFold
+			r.decoder.CheckSize = r.checkBodySize
+			r.decoder.CheckTrailers = r.checkHeaders
+			r.state = requestStateDecodingBody
+			return 0, nil
 		}
 
-		contentLength, err := strconv.Atoi(contentLengthStr)
+		// Without Content-Length or Transfer-Encoding a request has no body
+		// (RFC 9112 section 6.3), which Int reports as a length of 0.
+		length, _, err := r.Headers.Int("Content-Length")
 		if err != nil {
-			return 0, fmt.Errorf("request.parse:: %w", err)
+			return 0, fmt.Errorf(
+				"request.parse: %w: %w",
+				ErrInvalidContentLength,
+				err,
+			)
 		}
+		contentLength := int(length)
 
-		r.Body = append(r.Body, data...)
-		r.bodyLengthRead += len(data)
-		if r.bodyLengthRead > contentLength {
-			return 0, fmt.Errorf("too much body")
-		} else if r.bodyLengthRead == contentLength {
+		err = r.checkBodySize(contentLength)
+		if err != nil {
+			return 0, fmt.Errorf("request.parse: %w", err)
+		}
+
+		r.decoder = body.NewLengthDecoder(contentLength)
+		r.state = requestStateDecodingBody
+		return 0, nil
+	case requestStateDecodingBody:
+		n, err := r.decoder.Decode(data)
+		if err != nil {
+			return 0, fmt.Errorf("request.parse: %w", err)
+		}
+
+		if r.decoder.Done() {
 			r.state = requestStateDone
 		}
-		return len(data), nil
+		return n, nil
 	default:
 		return 0, fmt.Errorf("invalid state")
 	}
 }
+
+func (r *Request) PathValue(name string) string {
+	return r.PathParams[name]
+}
+
+// ClientCertificate returns the leaf of the client's verified certificate
+// chain, or nil if the client did not present one that was verified.
+func (r *Request) ClientCertificate() *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
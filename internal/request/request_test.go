@@ -2,8 +2,10 @@ package request
 
 import (
 	"io"
+	"strings"
 	"testing"
 
+	"github.com/davidw1457/httpfromtcp/internal/headers"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -45,6 +47,16 @@ func TestRequestLineParse(t *testing.T) {
 	assert.Equal(t, "/coffee", r.RequestLine.RequestTarget)
 	assert.Equal(t, "1.1", r.RequestLine.HttpVersion)
 
+	// Test: Empty line before the request line is ignored
+	reader = &chunkReader{
+		data:            "\r\nGET /coffee HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 1,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "GET", r.RequestLine.Method)
+	assert.Equal(t, "/coffee", r.RequestLine.RequestTarget)
+
 	// Test: Invalid number of parts in request line
 	reader = &chunkReader{
 		data:            "/coffee HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n",
@@ -79,9 +91,9 @@ func TestHeadersParse(t *testing.T) {
 	r, err := RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069", r.Headers["host"])
-	assert.Equal(t, "curl/7.81.0", r.Headers["user-agent"])
-	assert.Equal(t, "*/*", r.Headers["accept"])
+	assert.Equal(t, []string{"localhost:42069"}, r.Headers.Values("host"))
+	assert.Equal(t, []string{"curl/7.81.0"}, r.Headers.Values("user-agent"))
+	assert.Equal(t, []string{"*/*"}, r.Headers.Values("accept"))
 
 	// Test: Empty Headers
 	reader = &chunkReader{
@@ -109,7 +121,7 @@ func TestHeadersParse(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069, duplicate:8080", r.Headers["host"])
+	assert.Equal(t, []string{"localhost:42069", "duplicate:8080"}, r.Headers.Values("host"))
 
 	// Test: Case Insensitive Headers
 	reader = &chunkReader{
@@ -119,8 +131,8 @@ func TestHeadersParse(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069", r.Headers["host"])
-	assert.Equal(t, "curl/7.81.0", r.Headers["user-agent"])
+	assert.Equal(t, []string{"localhost:42069"}, r.Headers.Values("host"))
+	assert.Equal(t, []string{"curl/7.81.0"}, r.Headers.Values("user-agent"))
 
 	// Test: Missing End of Headers
 	reader = &chunkReader{
@@ -183,6 +195,458 @@ func TestBodyParse(t *testing.T) {
 	require.NoError(t, err)
 	require.NotNil(t, r)
 	assert.Equal(t, "", string(r.Body))
+
+	// Test: Identical duplicate Content-Length values collapse
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Content-Length: 5\r\n" +
+			"Content-Length: 5, 5\r\n" +
+			"\r\n" +
+			"hello",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"5"}, r.Headers.Values("Content-Length"))
+	assert.Equal(t, "hello", string(r.Body))
+
+	// Test: Conflicting Content-Length values are rejected
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Content-Length: 5\r\n" +
+			"Content-Length: 6\r\n" +
+			"\r\n" +
+			"hello!",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	assert.ErrorIs(t, err, headers.ErrConflictingContentLength)
+
+	// Test: Content-Length alongside Transfer-Encoding is rejected
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	assert.ErrorIs(t, err, ErrInvalidContentLength)
+}
+
+func TestObsFold(t *testing.T) {
+	data := "GET / HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"X-Folded: first\r\n" +
+		" \t second\r\n" +
+		"\r\n"
+
+	// Test: Obsolete line folding is rejected by default
+	_, err := RequestFromReader(&chunkReader{data: data, numBytesPerRead: 3})
+	assert.ErrorIs(t, err, headers.ErrObsFold)
+	assert.ErrorIs(t, err, headers.ErrMalformedHeader)
+
+	// Test: Obsolete line folding is replaced with SP when allowed
+	reader := NewReader(&chunkReader{data: data, numBytesPerRead: 3})
+	reader.ObsFold = headers.ObsFoldReplace
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"first second"}, r.Headers.Values("X-Folded"))
+	assert.Equal(t, []string{"localhost:42069"}, r.Headers.Values("Host"))
+}
+
+func TestChunkedBodyParse(t *testing.T) {
+	// Test: Standard chunked body
+	reader := &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"5\r\nhello\r\n" +
+			"7\r\n world!\r\n" +
+			"0\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "hello world!", string(r.Body))
+	assert.Empty(t, r.Trailers)
+
+	// Test: Chunk extensions and uppercase hex size
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"A;name=value\r\n0123456789\r\n" +
+			"0;last\r\n" +
+			"\r\n",
+		numBytesPerRead: 1,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "0123456789", string(r.Body))
+
+	// Test: Trailers after the final chunk
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"Trailer: X-Checksum\r\n" +
+			"\r\n" +
+			"4\r\ndata\r\n" +
+			"0\r\n" +
+			"X-Checksum: abc123\r\n" +
+			"\r\n",
+		numBytesPerRead: 4,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "data", string(r.Body))
+	assert.Equal(t, []string{"abc123"}, r.Trailers.Values("x-checksum"))
+	_, ok := r.Headers.Get("X-Checksum")
+	assert.False(t, ok)
+
+	// Test: Missing final chunk
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"5\r\nhello\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+
+	// Test: Invalid chunk size
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"zz\r\nhello\r\n0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+
+	// Test: Chunk longer than its declared size
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"3\r\nhello\r\n0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+
+	// Test: Unsupported transfer coding
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Transfer-Encoding: gzip\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+}
+
+func TestPipelinedRequests(t *testing.T) {
+	// Test: Requests read back to back from one reader
+	reader := NewReader(&chunkReader{
+		data: "POST /first HTTP/1.1\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"hello" +
+			"GET /second HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"\r\n" +
+			"POST /third HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"3\r\nabc\r\n0\r\n\r\n",
+		numBytesPerRead: 64,
+	})
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/first", r.RequestLine.RequestTarget)
+	assert.Equal(t, "hello", string(r.Body))
+
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/second", r.RequestLine.RequestTarget)
+	assert.Equal(t, "", string(r.Body))
+
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/third", r.RequestLine.RequestTarget)
+	assert.Equal(t, "abc", string(r.Body))
+
+	// Test: Clean EOF between requests
+	_, err = reader.ReadRequest()
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: Bodyless request does not swallow the next one
+	reader = NewReader(&chunkReader{
+		data:            "GET /a HTTP/1.1\r\n\r\nGET /b HTTP/1.1\r\n\r\n",
+		numBytesPerRead: 1,
+	})
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/a", r.RequestLine.RequestTarget)
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/b", r.RequestLine.RequestTarget)
+
+	// Test: Truncated second request
+	reader = NewReader(&chunkReader{
+		data:            "GET /a HTTP/1.1\r\n\r\nGET /b HTT",
+		numBytesPerRead: 7,
+	})
+	_, err = reader.ReadRequest()
+	require.NoError(t, err)
+	_, err = reader.ReadRequest()
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, io.EOF)
+}
+
+func TestStreamingBody(t *testing.T) {
+	// Test: Content-Length body read through BodyReader
+	reader := NewReader(&chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Content-Length: 13\r\n" +
+			"\r\n" +
+			"hello world!\n",
+		numBytesPerRead: 3,
+	})
+	reader.StreamBody = true
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/upload", r.RequestLine.RequestTarget)
+	assert.Empty(t, r.Body)
+	body, err := io.ReadAll(r.BodyReader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!\n", string(body))
+	require.NoError(t, r.BodyReader.Close())
+
+	// Test: Chunked body with trailers read through BodyReader
+	reader = NewReader(&chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"5\r\nhello\r\n" +
+			"6\r\n world\r\n" +
+			"0\r\n" +
+			"X-Checksum: abc123\r\n" +
+			"\r\n",
+		numBytesPerRead: 2,
+	})
+	reader.StreamBody = true
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	body, err = io.ReadAll(r.BodyReader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(body))
+	assert.Equal(t, []string{"abc123"}, r.Trailers.Values("x-checksum"))
+
+	// Test: Close drains an unread body before the next request
+	reader = NewReader(&chunkReader{
+		data: "POST /first HTTP/1.1\r\n" +
+			"Content-Length: 10\r\n" +
+			"\r\n" +
+			"0123456789" +
+			"GET /second HTTP/1.1\r\n" +
+			"\r\n",
+		numBytesPerRead: 4,
+	})
+	reader.StreamBody = true
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	p := make([]byte, 3)
+	n, err := r.BodyReader.Read(p)
+	require.NoError(t, err)
+	assert.Equal(t, "012", string(p[:n]))
+	require.NoError(t, r.BodyReader.Close())
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/second", r.RequestLine.RequestTarget)
+
+	// Test: Truncated streamed body
+	reader = NewReader(&chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Content-Length: 20\r\n" +
+			"\r\n" +
+			"partial content",
+		numBytesPerRead: 3,
+	})
+	reader.StreamBody = true
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.BodyReader)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// Test: Buffered mode still exposes a BodyReader
+	r, err = RequestFromReader(&chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"hello",
+		numBytesPerRead: 3,
+	})
+	require.NoError(t, err)
+	body, err = io.ReadAll(r.BodyReader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+}
+
+func TestLimits(t *testing.T) {
+	limits := Limits{
+		MaxRequestLineLength: 32,
+		MaxHeaderBytes:       64,
+		MaxHeaderCount:       3,
+		MaxBodySize:          10,
+	}
+
+	// Test: Request line within limits
+	reader := NewReader(&chunkReader{
+		data:            "GET /short HTTP/1.1\r\nHost: localhost\r\n\r\n",
+		numBytesPerRead: 3,
+	})
+	reader.Limits = limits
+	_, err := reader.ReadRequest()
+	require.NoError(t, err)
+
+	// Test: Request line too long
+	reader = NewReader(&chunkReader{
+		data:            "GET /" + strings.Repeat("a", 64) + " HTTP/1.1\r\n\r\n",
+		numBytesPerRead: 3,
+	})
+	reader.Limits = limits
+	_, err = reader.ReadRequest()
+	assert.ErrorIs(t, err, ErrRequestLineTooLong)
+
+	// Test: Request line without end is cut off
+	reader = NewReader(&chunkReader{
+		data:            "GET /" + strings.Repeat("a", 1024),
+		numBytesPerRead: 16,
+	})
+	reader.Limits = limits
+	_, err = reader.ReadRequest()
+	assert.ErrorIs(t, err, ErrRequestLineTooLong)
+
+	// Test: Single header line too long
+	reader = NewReader(&chunkReader{
+		data:            "GET / HTTP/1.1\r\nX-Long: " + strings.Repeat("a", 1024),
+		numBytesPerRead: 16,
+	})
+	reader.Limits = limits
+	_, err = reader.ReadRequest()
+	assert.ErrorIs(t, err, ErrHeadersTooLarge)
+
+	// Test: Too many headers
+	reader = NewReader(&chunkReader{
+		data:            "GET / HTTP/1.1\r\nA: 1\r\nB: 2\r\nC: 3\r\nD: 4\r\n\r\n",
+		numBytesPerRead: 3,
+	})
+	reader.Limits = limits
+	_, err = reader.ReadRequest()
+	assert.ErrorIs(t, err, ErrHeadersTooLarge)
+
+	// Test: Content-Length above the body limit
+	reader = NewReader(&chunkReader{
+		data:            "POST / HTTP/1.1\r\nContent-Length: 11\r\n\r\nhello world",
+		numBytesPerRead: 3,
+	})
+	reader.Limits = limits
+	_, err = reader.ReadRequest()
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+
+	// Test: Chunked body growing past the body limit
+	reader = NewReader(&chunkReader{
+		data: "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n" +
+			"6\r\nhello \r\n6\r\nworld!\r\n0\r\n\r\n",
+		numBytesPerRead: 3,
+	})
+	reader.Limits = limits
+	_, err = reader.ReadRequest()
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+
+	// Test: Zero limits disable checks
+	reader = NewReader(&chunkReader{
+		data:            "GET /" + strings.Repeat("a", 1024) + " HTTP/1.1\r\n\r\n",
+		numBytesPerRead: 64,
+	})
+	reader.Limits = Limits{}
+	_, err = reader.ReadRequest()
+	require.NoError(t, err)
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		err  error
+	}{
+		{
+			name: "Missing request line fields",
+			data: "/coffee HTTP/1.1\r\n\r\n",
+			err:  ErrMalformedRequestLine,
+		},
+		{
+			name: "Lowercase method",
+			data: "get / HTTP/1.1\r\n\r\n",
+			err:  ErrMalformedRequestLine,
+		},
+		{
+			name: "Garbage version",
+			data: "GET / TCP/1.1\r\n\r\n",
+			err:  ErrMalformedRequestLine,
+		},
+		{
+			name: "Unsupported version",
+			data: "GET / HTTP/2.0\r\n\r\n",
+			err:  ErrUnsupportedVersion,
+		},
+		{
+			name: "Non-numeric Content-Length",
+			data: "POST / HTTP/1.1\r\nContent-Length: five\r\n\r\nhello",
+			err:  ErrInvalidContentLength,
+		},
+		{
+			name: "Negative Content-Length",
+			data: "POST / HTTP/1.1\r\nContent-Length: -1\r\n\r\n",
+			err:  ErrInvalidContentLength,
+		},
+		{
+			name: "Unsupported transfer coding",
+			data: "POST / HTTP/1.1\r\nTransfer-Encoding: gzip\r\n\r\n",
+			err:  ErrUnsupportedTransferEncoding,
+		},
+		{
+			name: "Bad chunk size",
+			data: "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n" +
+				"xyz\r\n",
+			err: ErrMalformedChunk,
+		},
+		{
+			name: "Malformed header",
+			data: "GET / HTTP/1.1\r\nHost localhost\r\n\r\n",
+			err:  headers.ErrMalformedHeader,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := RequestFromReader(&chunkReader{
+				data:            tc.data,
+				numBytesPerRead: 3,
+			})
+			assert.ErrorIs(t, err, tc.err)
+		})
+	}
 }
 
 type chunkReader struct {
@@ -0,0 +1,16 @@
+package response
+
+// bodySource lets a streamed body pull the rest of its response through
+// the reader it came from.
+type bodySource struct {
+	reader   *Reader
+	response *Response
+}
+
+func (bs *bodySource) Parse() error {
+	return bs.reader.parseBuffered(bs.response)
+}
+
+func (bs *bodySource) ReadMore() error {
+	return bs.reader.readMore()
+}
@@ -0,0 +1,18 @@
+package response
+
+import (
+	"errors"
+
+	"github.com/davidw1457/httpfromtcp/internal/body"
+)
+
+// Errors returned while parsing a response. They are wrapped with context,
+// so match them with errors.Is.
+var (
+	ErrMalformedStatusLine         = errors.New("malformed status line")
+	ErrUnsupportedVersion          = errors.New("unsupported http version")
+	ErrInvalidContentLength        = errors.New("invalid content-length")
+	ErrUnsupportedTransferEncoding = errors.New("unsupported transfer-encoding")
+	ErrMalformedChunk              = body.ErrMalformedChunk
+	ErrHeadersTooLarge             = errors.New("response header fields too large")
+)
@@ -0,0 +1,428 @@
+package response
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/davidw1457/httpfromtcp/internal/body"
+	"github.com/davidw1457/httpfromtcp/internal/headers"
+)
+
+const (
+	readBufferSize = 1024
+	// maxHeaderBytes bounds the status line and header section of a single
+	// response, so a misbehaving server cannot grow the buffer forever.
+	maxHeaderBytes = 1024 * 1024
+)
+
+// Response is a response parsed from a server. Interim holds any 1xx
+// responses received before it, in order.
+type Response struct {
+	StatusLine StatusLine
+	Headers    headers.Headers
+	Body       []byte
+	BodyReader io.ReadCloser
+	Trailers   headers.Headers
+	Interim    []*Response
+
+	state           responseState
+	head            bool
+	closeDelimited  bool
+	decoder         *body.Decoder
+	obsFold         headers.ObsFoldPolicy
+	headerBytesRead int
+}
+
+type StatusLine struct {
+	HttpVersion string
+	StatusCode  StatusCode
+	Reason      string
+}
+
+type responseState int
+
+const (
+	responseStateInitialized responseState = iota
+	responseStateParsingHeaders
+	responseStateParsingBody
+	responseStateDecodingBody
+	responseStateDone
+)
+
+// Reader parses consecutive responses from a single connection. Bytes read
+// past the end of one response are kept for the next call to ReadResponse.
+//
+// When StreamBody is set, ReadResponse returns as soon as the headers are
+// parsed and the body is read through Response.BodyReader instead of being
+// collected into Response.Body. The BodyReader must be closed before the
+// next call to ReadResponse.
+//
+// ObsFold is what to do with obsolete line folding; NewReader sets it to
+// headers.ObsFoldReplace, as RFC 9112 requires of clients.
+type Reader struct {
+	StreamBody bool
+	ObsFold    headers.ObsFoldPolicy
+
+	reader      io.Reader
+	buf         []byte
+	readToIndex int
+}
+
+func NewReader(reader io.Reader) *Reader {
+	return &Reader{
+		ObsFold: headers.ObsFoldReplace,
+		reader:  reader,
+		buf:     make([]byte, readBufferSize),
+	}
+}
+
+// ResponseFromReader parses a single response to a request made with
+// method. The method only matters for HEAD, whose responses have no body.
+func ResponseFromReader(reader io.Reader, method string) (*Response, error) {
+	return NewReader(reader).ReadResponse(method)
+}
+
+// ReadResponse reads the final response to a request made with method,
+// collecting interim 1xx responses along the way. 101 Switching Protocols
+// is final; the connection no longer carries HTTP after it. It returns
+// io.EOF when the reader is exhausted before any byte of a response
+// arrives.
+func (rr *Reader) ReadResponse(method string) (*Response, error) {
+	var interim []*Response
+	for {
+		response, err := rr.readOne(method)
+		if err != nil {
+			if errors.Is(err, io.EOF) && len(interim) > 0 {
+				return nil, fmt.Errorf("incomplete response")
+			}
+			return nil, err
+		}
+
+		if response.interim() {
+			interim = append(interim, response)
+			continue
+		}
+
+		response.Interim = interim
+		return response, nil
+	}
+}
+
+func (rr *Reader) readOne(method string) (*Response, error) {
+	response := Response{
+		Headers:  headers.NewHeaders(),
+		Body:     make([]byte, 0),
+		Trailers: headers.NewHeaders(),
+		state:    responseStateInitialized,
+		head:     method == "HEAD",
+		obsFold:  rr.ObsFold,
+	}
+
+	for {
+		err := rr.parseBuffered(&response)
+		if err != nil {
+			return nil, fmt.Errorf("ResponseFromReader: %w", err)
+		}
+
+		if response.state == responseStateDone {
+			break
+		}
+		if rr.StreamBody && response.state > responseStateParsingHeaders {
+			break
+		}
+
+		err = rr.readMore()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				if response.state == responseStateDecodingBody &&
+					response.decoder.EOF() == nil {
+					response.state = responseStateDone
+					break
+				}
+				if response.state == responseStateInitialized &&
+					rr.readToIndex == 0 {
+					return nil, io.EOF
+				}
+				return nil, fmt.Errorf("incomplete response")
+			}
+			return nil, fmt.Errorf("ResponseFromReader: %w", err)
+		}
+	}
+
+	if rr.StreamBody {
+		response.BodyReader = body.NewReader(
+			&bodySource{reader: rr, response: &response},
+			response.decoder,
+		)
+	} else {
+		response.Body = append(response.Body, response.decoder.Bytes()...)
+		response.BodyReader = io.NopCloser(bytes.NewReader(response.Body))
+	}
+
+	return &response, nil
+}
+
+// Buffered returns the number of bytes read from the underlying reader
+// that have not been consumed by a response yet.
+func (rr *Reader) Buffered() int {
+	return rr.readToIndex
+}
+
+func (rr *Reader) parseBuffered(response *Response) error {
+	n, err := response.parse(rr.buf[:rr.readToIndex])
+	if err != nil {
+		return err
+	}
+
+	copy(rr.buf, rr.buf[n:rr.readToIndex])
+	rr.readToIndex -= n
+
+	return nil
+}
+
+func (rr *Reader) readMore() error {
+	if rr.readToIndex >= len(rr.buf) {
+		newBuf := make([]byte, len(rr.buf)*2)
+		copy(newBuf, rr.buf)
+		rr.buf = newBuf
+	}
+
+	n, err := rr.reader.Read(rr.buf[rr.readToIndex:])
+	rr.readToIndex += n
+	if n > 0 && errors.Is(err, io.EOF) {
+		return nil
+	}
+
+	return err
+}
+
+func parseStatusLine(input []byte) (*StatusLine, int, error) {
+	idx := bytes.Index(input, []byte("\r\n"))
+	if idx == -1 {
+		return nil, 0, nil
+	}
+	statusLineText := string(input[:idx])
+	statusLine, err := statusLineFromString(statusLineText)
+	if err != nil {
+		return nil, 0, fmt.Errorf("parseStatusLine: %w", err)
+	}
+
+	return statusLine, idx + 2, nil
+}
+
+func statusLineFromString(line string) (*StatusLine, error) {
+	version, rest, ok := strings.Cut(line, " ")
+	if !ok {
+		return nil, fmt.Errorf(
+			"statusLineFromString: %w: missing fields %s",
+			ErrMalformedStatusLine,
+			line,
+		)
+	}
+
+	if version != "HTTP/1.1" && version != "HTTP/1.0" {
+		validVersion, err := regexp.MatchString("^HTTP/[0-9]\\.[0-9]$", version)
+		if err != nil {
+			return nil, fmt.Errorf("statusLineFromString: %w", err)
+		} else if validVersion {
+			return nil, fmt.Errorf(
+				"statusLineFromString: %w: %s",
+				ErrUnsupportedVersion,
+				version,
+			)
+		}
+		return nil, fmt.Errorf(
+			"statusLineFromString: %w: invalid http version: %s",
+			ErrMalformedStatusLine,
+			version,
+		)
+	}
+
+	codeText, reason, _ := strings.Cut(rest, " ")
+	validCode, err := regexp.MatchString("^[0-9]{3}$", codeText)
+	if err != nil {
+		return nil, fmt.Errorf("statusLineFromString: %w", err)
+	} else if !validCode {
+		return nil, fmt.Errorf(
+			"statusLineFromString: %w: invalid status code: %s",
+			ErrMalformedStatusLine,
+			codeText,
+		)
+	}
+
+	code, err := strconv.Atoi(codeText)
+	if err != nil {
+		return nil, fmt.Errorf("statusLineFromString: %w", err)
+	}
+
+	err = validateStatusLine(StatusCode(code), reason)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"statusLineFromString: %w: %w",
+			ErrMalformedStatusLine,
+			err,
+		)
+	}
+
+	return &StatusLine{
+		HttpVersion: strings.TrimPrefix(version, "HTTP/"),
+		StatusCode:  StatusCode(code),
+		Reason:      reason,
+	}, nil
+}
+
+func (r *Response) parse(data []byte) (int, error) {
+	bytesParsed := 0
+	for r.state != responseStateDone {
+		state := r.state
+		n, err := r.parseSingle(data[bytesParsed:])
+		if err != nil {
+			return n, fmt.Errorf("response.parse: %w", err)
+		}
+		bytesParsed += n
+		if n == 0 && r.state == state {
+			break
+		}
+	}
+	return bytesParsed, nil
+}
+
+func (r *Response) parseSingle(data []byte) (int, error) {
+	switch r.state {
+	case responseStateDone:
+		return 0, fmt.Errorf("error: trying to read data in a done state")
+	case responseStateInitialized:
+		statusLine, n, err := parseStatusLine(data)
+		if err != nil {
+			return 0, fmt.Errorf("response.parse: %w", err)
+		}
+
+		err = r.checkHeaderBytes(data, n)
+		if err != nil {
+			return 0, fmt.Errorf("response.parse: %w", err)
+		}
+
+		if n == 0 {
+			return 0, nil
+		}
+
+		r.StatusLine = *statusLine
+		r.state = responseStateParsingHeaders
+
+		return n, nil
+	case responseStateParsingHeaders:
+		n, done, err := r.Headers.ParseFolded(data, r.obsFold)
+		if err != nil {
+			return 0, fmt.Errorf("response.parse: %w", err)
+		}
+
+		err = r.checkHeaderBytes(data, n)
+		if err != nil {
+			return 0, fmt.Errorf("response.parse: %w", err)
+		}
+
+		if done {
+			r.state = responseStateParsingBody
+		}
+		return n, nil
+	case responseStateParsingBody:
+		r.state = responseStateDecodingBody
+		if !r.hasBody() {
+			r.decoder = body.NewLengthDecoder(0)
+			return 0, nil
+		}
+
+		transferEncoding, ok := r.Headers.Get("Transfer-Encoding")
+		if ok {
+			if !strings.EqualFold(strings.TrimSpace(transferEncoding), "chunked") {
+				return 0, fmt.Errorf(
+					"response.parse: %w: %s",
+					ErrUnsupportedTransferEncoding,
+					transferEncoding,
+				)
+			}
+			r.decoder = body.NewChunkedDecoder(r.Trailers)
+			r.decoder.ObsFold = r.obsFold
+			r.decoder.CheckTrailers = func(data []byte, n int, _ bool) error {
+				return r.checkHeaderBytes(data, n)
+			}
+			return 0, nil
+		}
+
+		contentLength, ok, err := r.Headers.Int("Content-Length")
+		if !ok {
+			r.closeDelimited = true
+			r.decoder = body.NewCloseDecoder()
+			return 0, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf(
+				"response.parse: %w: %w",
+				ErrInvalidContentLength,
+				err,
+			)
+		}
+
+		r.decoder = body.NewLengthDecoder(int(contentLength))
+		return 0, nil
+	case responseStateDecodingBody:
+		n, err := r.decoder.Decode(data)
+		if err != nil {
+			return 0, fmt.Errorf("response.parse: %w", err)
+		}
+
+		if r.decoder.Done() {
+			r.state = responseStateDone
+		}
+		return n, nil
+	default:
+		return 0, fmt.Errorf("invalid state")
+	}
+}
+
+// hasBody follows RFC 9112 section 6.3: responses to HEAD, 1xx, 204 and
+// 304 responses never have a body, whatever their header fields say.
+func (r *Response) hasBody() bool {
+	code := r.StatusLine.StatusCode
+	return !r.head && code >= OK && code != NOCONTENT && code != NOTMODIFIED
+}
+
+// KeepAlive reports whether the connection may carry another response once
+// this one's body has been read to the end.
+func (r *Response) KeepAlive() bool {
+	if r.closeDelimited || r.StatusLine.StatusCode == SWITCHINGPROTOCOLS {
+		return false
+	}
+	if r.Headers.ContainsToken("Connection", "close") {
+		return false
+	}
+	if r.StatusLine.HttpVersion == "1.0" {
+		return r.Headers.ContainsToken("Connection", "keep-alive")
+	}
+	return true
+}
+
+func (r *Response) interim() bool {
+	code := r.StatusLine.StatusCode
+	return code < OK && code != SWITCHINGPROTOCOLS
+}
+
+// checkHeaderBytes counts the status line, header and trailer bytes
+// consumed so far, including a line still waiting for its CRLF.
+func (r *Response) checkHeaderBytes(data []byte, n int) error {
+	pending := n
+	if n == 0 {
+		pending = len(data)
+	}
+	if r.headerBytesRead+pending > maxHeaderBytes {
+		return ErrHeadersTooLarge
+	}
+
+	r.headerBytesRead += n
+	return nil
+}
@@ -0,0 +1,238 @@
+package response
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseFromReader(t *testing.T) {
+	// Test: Content-Length body read one byte at a time
+	reader := iotest.OneByteReader(strings.NewReader(
+		"HTTP/1.1 200 OK\r\n" +
+			"Content-Type: text/plain\r\n" +
+			"Content-Length: 13\r\n" +
+			"\r\n" +
+			"hello, world!",
+	))
+	r, err := ResponseFromReader(reader, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "1.1", r.StatusLine.HttpVersion)
+	assert.Equal(t, OK, r.StatusLine.StatusCode)
+	assert.Equal(t, "OK", r.StatusLine.Reason)
+	contentType, _ := r.Headers.Get("Content-Type")
+	assert.Equal(t, "text/plain", contentType)
+	assert.Equal(t, "hello, world!", string(r.Body))
+
+	// Test: Chunked body with trailers
+	r, err = ResponseFromReader(strings.NewReader(
+		"HTTP/1.1 200 OK\r\n"+
+			"Transfer-Encoding: chunked\r\n"+
+			"Trailer: X-Checksum\r\n"+
+			"\r\n"+
+			"5\r\nhello\r\n"+
+			"7;ext=1\r\n, world\r\n"+
+			"0\r\n"+
+			"X-Checksum: abc\r\n"+
+			"\r\n",
+	), "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world", string(r.Body))
+	checksum, _ := r.Trailers.Get("X-Checksum")
+	assert.Equal(t, "abc", checksum)
+
+	// Test: Body delimited by the connection closing
+	r, err = ResponseFromReader(strings.NewReader(
+		"HTTP/1.0 200 OK\r\n\r\nuntil close",
+	), "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "1.0", r.StatusLine.HttpVersion)
+	assert.Equal(t, "until close", string(r.Body))
+
+	// Test: Empty and missing reason phrase
+	r, err = ResponseFromReader(strings.NewReader(
+		"HTTP/1.1 299 \r\nContent-Length: 0\r\n\r\n",
+	), "GET")
+	require.NoError(t, err)
+	assert.Equal(t, StatusCode(299), r.StatusLine.StatusCode)
+	assert.Empty(t, r.StatusLine.Reason)
+	r, err = ResponseFromReader(strings.NewReader(
+		"HTTP/1.1 200\r\nContent-Length: 0\r\n\r\n",
+	), "GET")
+	require.NoError(t, err)
+	assert.Empty(t, r.StatusLine.Reason)
+
+	// Test: Obsolete line folding is replaced with SP
+	r, err = ResponseFromReader(strings.NewReader(
+		"HTTP/1.1 200 OK\r\n"+
+			"X-Folded: first\r\n"+
+			"\tsecond\r\n"+
+			"Content-Length: 0\r\n"+
+			"\r\n",
+	), "GET")
+	require.NoError(t, err)
+	folded, _ := r.Headers.Get("X-Folded")
+	assert.Equal(t, "first second", folded)
+}
+
+func TestBodilessResponses(t *testing.T) {
+	raw := "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\n" +
+		"HTTP/1.1 204 No Content\r\nContent-Length: 5\r\n\r\n" +
+		"HTTP/1.1 304 Not Modified\r\nContent-Length: 5\r\n\r\n" +
+		"HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello"
+	reader := NewReader(strings.NewReader(raw))
+
+	// Test: Response to HEAD has no body despite Content-Length
+	r, err := reader.ReadResponse("HEAD")
+	require.NoError(t, err)
+	assert.Empty(t, r.Body)
+
+	// Test: 204 and 304 have no body
+	r, err = reader.ReadResponse("GET")
+	require.NoError(t, err)
+	assert.Equal(t, NOCONTENT, r.StatusLine.StatusCode)
+	assert.Empty(t, r.Body)
+	r, err = reader.ReadResponse("GET")
+	require.NoError(t, err)
+	assert.Equal(t, NOTMODIFIED, r.StatusLine.StatusCode)
+	assert.Empty(t, r.Body)
+
+	// Test: Next response on the connection is intact
+	r, err = reader.ReadResponse("GET")
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(r.Body))
+
+	// Test: Exhausted reader
+	_, err = reader.ReadResponse("GET")
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestInterimResponses(t *testing.T) {
+	// Test: 1xx responses are collected before the final response
+	r, err := ResponseFromReader(strings.NewReader(
+		"HTTP/1.1 100 Continue\r\n\r\n"+
+			"HTTP/1.1 103 Early Hints\r\nLink: </style.css>\r\n\r\n"+
+			"HTTP/1.1 201 Created\r\nContent-Length: 2\r\n\r\nok",
+	), "POST")
+	require.NoError(t, err)
+	assert.Equal(t, CREATED, r.StatusLine.StatusCode)
+	assert.Equal(t, "ok", string(r.Body))
+	require.Len(t, r.Interim, 2)
+	assert.Equal(t, CONTINUE, r.Interim[0].StatusLine.StatusCode)
+	link, _ := r.Interim[1].Headers.Get("Link")
+	assert.Equal(t, "</style.css>", link)
+
+	// Test: 101 is final and leaves the rest of the stream unread
+	reader := NewReader(strings.NewReader(
+		"HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\n\r\nframes",
+	))
+	r, err = reader.ReadResponse("GET")
+	require.NoError(t, err)
+	assert.Equal(t, SWITCHINGPROTOCOLS, r.StatusLine.StatusCode)
+	assert.Empty(t, r.Body)
+	assert.Equal(t, len("frames"), reader.Buffered())
+
+	// Test: Stream ends after an interim response
+	_, err = ResponseFromReader(strings.NewReader(
+		"HTTP/1.1 100 Continue\r\n\r\n",
+	), "GET")
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, io.EOF)
+}
+
+func TestStreamingResponseBody(t *testing.T) {
+	reader := NewReader(iotest.OneByteReader(strings.NewReader(
+		"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n" +
+			"5\r\nhello\r\n0\r\nX-Done: yes\r\n\r\n" +
+			"HTTP/1.1 200 OK\r\n\r\nclose delimited",
+	)))
+	reader.StreamBody = true
+
+	// Test: Chunked body streams and fills trailers at EOF
+	r, err := reader.ReadResponse("GET")
+	require.NoError(t, err)
+	assert.Empty(t, r.Body)
+	body, err := io.ReadAll(r.BodyReader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+	done, _ := r.Trailers.Get("X-Done")
+	assert.Equal(t, "yes", done)
+	require.NoError(t, r.BodyReader.Close())
+
+	// Test: Close-delimited body streams until EOF
+	r, err = reader.ReadResponse("GET")
+	require.NoError(t, err)
+	body, err = io.ReadAll(r.BodyReader)
+	require.NoError(t, err)
+	assert.Equal(t, "close delimited", string(body))
+
+	// Test: Truncated Content-Length body
+	reader = NewReader(strings.NewReader(
+		"HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nabc",
+	))
+	reader.StreamBody = true
+	r, err = reader.ReadResponse("GET")
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.BodyReader)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestResponseParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		err  error
+	}{
+		{
+			name: "missing status code",
+			raw:  "HTTP/1.1\r\n\r\n",
+			err:  ErrMalformedStatusLine,
+		},
+		{
+			name: "non-numeric status code",
+			raw:  "HTTP/1.1 OK\r\n\r\n",
+			err:  ErrMalformedStatusLine,
+		},
+		{
+			name: "two digit status code",
+			raw:  "HTTP/1.1 99 Low\r\n\r\n",
+			err:  ErrMalformedStatusLine,
+		},
+		{
+			name: "control character in reason",
+			raw:  "HTTP/1.1 200 O\x01K\r\n\r\n",
+			err:  ErrMalformedStatusLine,
+		},
+		{
+			name: "unsupported version",
+			raw:  "HTTP/2.0 200 OK\r\n\r\n",
+			err:  ErrUnsupportedVersion,
+		},
+		{
+			name: "invalid content-length",
+			raw:  "HTTP/1.1 200 OK\r\nContent-Length: -1\r\n\r\n",
+			err:  ErrInvalidContentLength,
+		},
+		{
+			name: "unsupported transfer-encoding",
+			raw:  "HTTP/1.1 200 OK\r\nTransfer-Encoding: gzip\r\n\r\n",
+			err:  ErrUnsupportedTransferEncoding,
+		},
+		{
+			name: "malformed chunk size",
+			raw:  "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n",
+			err:  ErrMalformedChunk,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ResponseFromReader(strings.NewReader(tt.raw), "GET")
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+}
@@ -4,22 +4,26 @@ import (
 	"fmt"
 	"io"
 	"strconv"
-	"strings"
 
 	"github.com/davidw1457/httpfromtcp/internal/headers"
 )
 
-type StatusCode int
-
-const (
-	OK          StatusCode = 200
-	BADREQUEST  StatusCode = 400
-	SERVERERROR StatusCode = 500
-)
-
+// Writer writes a single response. Besides writing, it records what was
+// written so that middleware wrapping a handler can observe the status
+// code, header fields and body size after the handler returns, and it can
+// carry header fields added by middleware before the handler runs.
 type Writer struct {
 	writer io.Writer
 	state  writerState
+	header headers.Headers
+
+	writtenHeaders  headers.Headers
+	statusCode      StatusCode
+	closeConnection bool
+	chunked         bool
+	contentLength   int
+	bodyLengthWrote int
+	omitBody        bool
 }
 
 type writerState int
@@ -29,39 +33,48 @@ const (
 	writerStateHeaders
 	writerStateBody
 	writerStateTrailers
+	writerStateDone
 )
 
 func NewWriter(w io.Writer) *Writer {
 	return &Writer{
-		writer: w,
-		state:  writerStateStatusLine,
+		writer:        w,
+		state:         writerStateStatusLine,
+		header:        headers.NewHeaders(),
+		contentLength: -1,
 	}
 }
 
 func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
+	err := w.WriteStatusLineWithReason(statusCode, StatusText(statusCode))
+	if err != nil {
+		return fmt.Errorf("writeStatusLine: %w", err)
+	}
+
+	return nil
+}
+
+func (w *Writer) WriteStatusLineWithReason(
+	statusCode StatusCode,
+	reason string,
+) error {
 	if w.state != writerStateStatusLine {
 		return fmt.Errorf("writer not in writerStateStatusLine state")
 	}
 
-	var phrase string
-	switch statusCode {
-	case OK:
-		phrase = "OK"
-	case BADREQUEST:
-		phrase = "Bad Request"
-	case SERVERERROR:
-		phrase = "Internal Server Error"
-	default:
-		phrase = ""
+	err := validateStatusLine(statusCode, reason)
+	if err != nil {
+		return fmt.Errorf("writeStatusLineWithReason: %w", err)
 	}
 
-	statusLine := []byte(fmt.Sprintf("HTTP/1.1 %d %s\r\n", statusCode, phrase))
+	statusLine := []byte(fmt.Sprintf("HTTP/1.1 %d %s\r\n", statusCode, reason))
 
-	_, err := w.writer.Write(statusLine)
+	_, err = w.writer.Write(statusLine)
 	if err != nil {
-		return fmt.Errorf("writeStatusLine: %w", err)
+		return fmt.Errorf("writeStatusLineWithReason: %w", err)
 	}
 
+	w.statusCode = statusCode
 	w.state = writerStateHeaders
 
 	return nil
@@ -71,29 +84,60 @@ func GetDefaultHeaders(contentLen int) headers.Headers {
 	header := headers.NewHeaders()
 
 	header.Set("Content-Length", strconv.Itoa(contentLen))
-	header.Set("Connection", "close")
-	header.Set("content-type", "text/plain")
+	header.Set("Content-Type", "text/plain")
 
 	return header
 }
 
-func (w *Writer) WriteHeaders(headers headers.Headers) error {
+// OmitBody makes the writer answer a HEAD request: the status line and
+// header fields, Content-Length included, are sent as written, while body
+// bytes, chunked framing and trailers are counted but not sent. It lets a
+// GET handler serve HEAD unchanged.
+func (w *Writer) OmitBody() {
+	w.omitBody = true
+}
+
+// Header returns header fields that WriteHeaders adds to the response
+// unless the handler sets the same field itself.
+func (w *Writer) Header() headers.Headers {
+	return w.header
+}
+
+func (w *Writer) WriteHeaders(h headers.Headers) error {
 	if w.state != writerStateHeaders {
 		return fmt.Errorf("writer not in writerStateHeaders")
 	}
-	for k, v := range headers {
-		headerLine := []byte(fmt.Sprintf("%s: %s\r\n", k, v))
-		_, err := w.writer.Write(headerLine)
-		if err != nil {
-			return fmt.Errorf("WriteHeaders: %w", err)
+
+	headers := w.header.Clone()
+	headers.Merge(h)
+
+	err := headers.Validate()
+	if err != nil {
+		return fmt.Errorf("WriteHeaders: %w", err)
+	}
+
+	for k, values := range headers.All() {
+		for _, v := range values {
+			headerLine := []byte(fmt.Sprintf("%s: %s\r\n", k, v))
+			_, err := w.writer.Write(headerLine)
+			if err != nil {
+				return fmt.Errorf("WriteHeaders: %w", err)
+			}
 		}
 	}
 
-	_, err := w.writer.Write([]byte("\r\n"))
+	_, err = w.writer.Write([]byte("\r\n"))
 	if err != nil {
 		return fmt.Errorf("WriteHeaders: %w", err)
 	}
 
+	w.writtenHeaders = headers
+	w.closeConnection = headers.ContainsToken("Connection", "close")
+	w.chunked = headers.ContainsToken("Transfer-Encoding", "chunked")
+	if contentLength, ok, err := headers.Int("Content-Length"); ok && err == nil {
+		w.contentLength = int(contentLength)
+	}
+
 	w.state = writerStateBody
 
 	return nil
@@ -103,10 +147,30 @@ func (w *Writer) WriteBody(p []byte) (int, error) {
 	if w.state != writerStateBody {
 		return 0, fmt.Errorf("writer not in writerStatebody")
 	}
+	if w.omitBody {
+		w.bodyLengthWrote += len(p)
+		return len(p), nil
+	}
 	n, err := w.writer.Write(p)
 	if err != nil {
 		return 0, fmt.Errorf("writer.WriteBody: %w", err)
 	}
+	w.bodyLengthWrote += n
+
+	return n, nil
+}
+
+func (w *Writer) writeFraming(p []byte) (int, error) {
+	if w.state != writerStateBody {
+		return 0, fmt.Errorf("writer not in writerStatebody")
+	}
+	if w.omitBody {
+		return len(p), nil
+	}
+	n, err := w.writer.Write(p)
+	if err != nil {
+		return 0, fmt.Errorf("writer.writeFraming: %w", err)
+	}
 
 	return n, nil
 }
@@ -116,7 +180,7 @@ func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
 
 	bytesWritten := 0
 
-	n, err := w.WriteBody([]byte(fmt.Sprintf("%s\r\n", length)))
+	n, err := w.writeFraming([]byte(fmt.Sprintf("%s\r\n", length)))
 	if err != nil {
 		return 0, fmt.Errorf("writer.WriteChunkedBody: %w", err)
 	}
@@ -128,7 +192,7 @@ func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
 	}
 	bytesWritten += n
 
-	n, err = w.WriteBody([]byte("\r\n"))
+	n, err = w.writeFraming([]byte("\r\n"))
 	if err != nil {
 		return 0, fmt.Errorf("writer.WriteChunkedBody: %w", err)
 	}
@@ -138,7 +202,7 @@ func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
 }
 
 func (w *Writer) WriteChunkedBodyDone() (int, error) {
-	n, err := w.WriteBody([]byte("0\r\n"))
+	n, err := w.writeFraming([]byte("0\r\n"))
 	if err != nil {
 		return 0, fmt.Errorf("writer.WriteChunkedBodyDone: %w", err)
 	}
@@ -149,20 +213,39 @@ func (w *Writer) WriteChunkedBodyDone() (int, error) {
 }
 
 func (w *Writer) WriteTrailers(h headers.Headers) error {
-	trailers, _ := h.Get("Trailer")
+	trailerKeys := h.List("Trailer")
 
-	trailerKeys := strings.Split(trailers, ",")
+	// Check every field before writing any, so that a rejected trailer
+	// leaves nothing half written.
 	for _, k := range trailerKeys {
-		k = strings.TrimSpace(k)
-		v, ok := h.Get(k)
-		if !ok {
-			continue
+		if !headers.IsToken(k) {
+			return fmt.Errorf(
+				"writer.WriteTrailers: %w",
+				&headers.InvalidFieldError{Name: k},
+			)
 		}
+		for _, v := range h.Values(k) {
+			if !headers.IsValidValue(v) {
+				return fmt.Errorf(
+					"writer.WriteTrailers: %w",
+					&headers.InvalidFieldError{Name: k, Value: v},
+				)
+			}
+		}
+	}
+
+	if w.omitBody {
+		w.state = writerStateDone
+		return nil
+	}
 
-		trailerLine := []byte(fmt.Sprintf("%s: %s\r\n", k, v))
-		_, err := w.writer.Write(trailerLine)
-		if err != nil {
-			return fmt.Errorf("writer.WriteTrailers: %w", err)
+	for _, k := range trailerKeys {
+		for _, v := range h.Values(k) {
+			trailerLine := []byte(fmt.Sprintf("%s: %s\r\n", k, v))
+			_, err := w.writer.Write(trailerLine)
+			if err != nil {
+				return fmt.Errorf("writer.WriteTrailers: %w", err)
+			}
 		}
 	}
 
@@ -171,5 +254,54 @@ func (w *Writer) WriteTrailers(h headers.Headers) error {
 		return fmt.Errorf("writer.WriteTrailers: %w", err)
 	}
 
+	w.state = writerStateDone
+
 	return nil
 }
+
+// KeepAlive reports whether a complete, properly framed response has been
+// written and the connection may carry another one.
+func (w *Writer) KeepAlive() bool {
+	if w.closeConnection {
+		return false
+	}
+	if w.omitBody && w.state >= writerStateBody {
+		return w.statusCode >= OK
+	}
+
+	switch w.state {
+	case writerStateStatusLine, writerStateHeaders, writerStateTrailers:
+		return false
+	case writerStateDone:
+		return true
+	}
+
+	if w.chunked {
+		return false
+	}
+	if w.statusCode < OK {
+		return false
+	}
+	if w.statusCode == NOCONTENT || w.statusCode == NOTMODIFIED {
+		return true
+	}
+	return w.contentLength == w.bodyLengthWrote
+}
+
+// StatusCode returns the status code written so far, or 0 if the status
+// line has not been written yet.
+func (w *Writer) StatusCode() StatusCode {
+	return w.statusCode
+}
+
+// WrittenHeaders returns the header fields sent with the response, or nil
+// if they have not been written yet.
+func (w *Writer) WrittenHeaders() headers.Headers {
+	return w.writtenHeaders
+}
+
+// BytesWritten returns the number of body bytes written, not counting
+// chunked framing or trailers.
+func (w *Writer) BytesWritten() int {
+	return w.bodyLengthWrote
+}
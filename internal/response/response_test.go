@@ -0,0 +1,206 @@
+package response
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/davidw1457/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteStatusLine(t *testing.T) {
+	// Test: Registered status code uses its standard reason phrase
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	err := w.WriteStatusLine(NOTFOUND)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 404 Not Found\r\n", buf.String())
+
+	// Test: Unregistered status code gets an empty reason phrase
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	err = w.WriteStatusLine(StatusCode(299))
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 299 \r\n", buf.String())
+
+	// Test: Custom reason phrase
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	err = w.WriteStatusLineWithReason(TOOMANYREQUESTS, "Slow Down")
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 429 Slow Down\r\n", buf.String())
+
+	// Test: Status code below range
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	err = w.WriteStatusLine(StatusCode(99))
+	require.Error(t, err)
+	assert.Empty(t, buf.String())
+
+	// Test: Status code above range
+	w = NewWriter(buf)
+	err = w.WriteStatusLine(StatusCode(1000))
+	require.Error(t, err)
+	assert.Empty(t, buf.String())
+
+	// Test: Reason phrase containing CRLF
+	w = NewWriter(buf)
+	err = w.WriteStatusLineWithReason(OK, "OK\r\nX-Injected: 1")
+	require.Error(t, err)
+	assert.Empty(t, buf.String())
+}
+
+func TestStatusText(t *testing.T) {
+	assert.Equal(t, "Created", StatusText(CREATED))
+	assert.Equal(t, "No Content", StatusText(NOCONTENT))
+	assert.Equal(t, "Moved Permanently", StatusText(MOVEDPERMANENTLY))
+	assert.Equal(t, "Not Modified", StatusText(NOTMODIFIED))
+	assert.Equal(t, "Method Not Allowed", StatusText(METHODNOTALLOWED))
+	assert.Equal(t, "Service Unavailable", StatusText(SERVICEUNAVAILABLE))
+	assert.Equal(t, "", StatusText(StatusCode(599)))
+}
+
+func TestWriterObservation(t *testing.T) {
+	// Test: Middleware headers are merged, handler headers win
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	w.Header().Set("X-Request-ID", "abc")
+	w.Header().Set("Content-Type", "application/json")
+	assert.Equal(t, StatusCode(0), w.StatusCode())
+	assert.Nil(t, w.WrittenHeaders())
+
+	require.NoError(t, w.WriteStatusLine(CREATED))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(5)))
+	_, err := w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+
+	assert.Equal(t, CREATED, w.StatusCode())
+	assert.Equal(t, []string{"abc"}, w.WrittenHeaders().Values("x-request-id"))
+	assert.Equal(t, []string{"text/plain"}, w.WrittenHeaders().Values("content-type"))
+	assert.Equal(t, 5, w.BytesWritten())
+	assert.Contains(t, buf.String(), "X-Request-ID: abc\r\n")
+	assert.True(t, w.KeepAlive())
+
+	// Test: Chunked framing is not counted as body bytes
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	h := GetDefaultHeaders(0)
+	h.Delete("Content-Length")
+	h.Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(h))
+	_, err = w.WriteChunkedBody([]byte("hello world"))
+	require.NoError(t, err)
+	assert.False(t, w.KeepAlive())
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	require.NoError(t, w.WriteTrailers(h))
+	assert.Equal(t, 11, w.BytesWritten())
+	assert.True(t, w.KeepAlive())
+
+	// Test: An interim response alone is never a complete response
+	w = NewWriter(&bytes.Buffer{})
+	require.NoError(t, w.WriteStatusLine(CONTINUE))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+	assert.False(t, w.KeepAlive())
+}
+
+func TestOmitBody(t *testing.T) {
+	// Test: Body bytes are counted but not sent, Content-Length is kept
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	w.OmitBody()
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(5)))
+	n, err := w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, 5, w.BytesWritten())
+	assert.True(t, strings.HasSuffix(buf.String(), "Content-Length: 5\r\nContent-Type: text/plain\r\n\r\n"))
+	assert.True(t, w.KeepAlive())
+
+	// Test: Chunked framing and trailers are not sent either
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	w.OmitBody()
+	h := headers.NewHeaders()
+	h.Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(h))
+	assert.True(t, w.KeepAlive())
+	_, err = w.WriteChunkedBody([]byte("hello"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	require.NoError(t, w.WriteTrailers(headers.NewHeaders()))
+	assert.True(t, strings.HasSuffix(buf.String(), "Transfer-Encoding: chunked\r\n\r\n"))
+}
+
+func TestHeaderInjection(t *testing.T) {
+	tests := []struct {
+		name  string
+		key   string
+		value string
+	}{
+		{"CRLF in value", "X-Name", "a\r\nSet-Cookie: evil=1"},
+		{"bare LF in value", "X-Name", "a\nb"},
+		{"bare CR in value", "X-Name", "a\rb"},
+		{"NUL in value", "X-Name", "a\x00b"},
+		{"colon in name", "X-Na:me", "a"},
+		{"space in name", "X Name", "a"},
+		{"CRLF in name", "X-Name\r\nEvil", "a"},
+	}
+
+	for _, tt := range tests {
+		// Test: Invalid field is rejected before anything is written
+		buf := &bytes.Buffer{}
+		w := NewWriter(buf)
+		require.NoError(t, w.WriteStatusLine(OK))
+		written := buf.Len()
+
+		h := GetDefaultHeaders(0)
+		h.Set(tt.key, tt.value)
+		err := w.WriteHeaders(h)
+		require.Error(t, err, tt.name)
+		var fieldErr *headers.InvalidFieldError
+		require.True(t, errors.As(err, &fieldErr), tt.name)
+		assert.Equal(t, tt.key, fieldErr.Name, tt.name)
+		assert.ErrorIs(t, err, headers.ErrMalformedHeader, tt.name)
+		assert.Equal(t, written, buf.Len(), tt.name)
+
+		// Test: Writer can still send a valid response
+		require.NoError(t, w.WriteHeaders(GetDefaultHeaders(0)), tt.name)
+	}
+
+	// Test: Invalid trailer value is rejected before anything is written
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(OK))
+	h := headers.NewHeaders()
+	h.Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteHeaders(h))
+	_, err := w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	written := buf.Len()
+
+	trailers := headers.NewHeaders()
+	trailers.Set("Trailer", "X-Checksum, X-Note")
+	trailers.Set("X-Checksum", "abc")
+	trailers.Set("X-Note", "ok\r\n\r\nHTTP/1.1 200 OK")
+	err = w.WriteTrailers(trailers)
+	assert.ErrorIs(t, err, headers.ErrMalformedHeader)
+	assert.Equal(t, written, buf.Len())
+
+	// Test: Invalid trailer name is rejected
+	trailers.Set("Trailer", "X-Checksum, Bad Name")
+	err = w.WriteTrailers(trailers)
+	assert.ErrorIs(t, err, headers.ErrMalformedHeader)
+
+	// Test: Valid trailers are written
+	trailers.Set("Trailer", "X-Checksum")
+	require.NoError(t, w.WriteTrailers(trailers))
+	assert.Equal(t, "X-Checksum: abc\r\n\r\n", buf.String()[written:])
+}
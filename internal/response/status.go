@@ -0,0 +1,163 @@
+package response
+
+import "fmt"
+
+type StatusCode int
+
+// Status codes registered with IANA in the HTTP Status Code Registry.
+const (
+	CONTINUE           StatusCode = 100
+	SWITCHINGPROTOCOLS StatusCode = 101
+	PROCESSING         StatusCode = 102
+	EARLYHINTS         StatusCode = 103
+
+	OK                   StatusCode = 200
+	CREATED              StatusCode = 201
+	ACCEPTED             StatusCode = 202
+	NONAUTHORITATIVEINFO StatusCode = 203
+	NOCONTENT            StatusCode = 204
+	RESETCONTENT         StatusCode = 205
+	PARTIALCONTENT       StatusCode = 206
+	MULTISTATUS          StatusCode = 207
+	ALREADYREPORTED      StatusCode = 208
+	IMUSED               StatusCode = 226
+
+	MULTIPLECHOICES   StatusCode = 300
+	MOVEDPERMANENTLY  StatusCode = 301
+	FOUND             StatusCode = 302
+	SEEOTHER          StatusCode = 303
+	NOTMODIFIED       StatusCode = 304
+	USEPROXY          StatusCode = 305
+	TEMPORARYREDIRECT StatusCode = 307
+	PERMANENTREDIRECT StatusCode = 308
+
+	BADREQUEST                 StatusCode = 400
+	UNAUTHORIZED               StatusCode = 401
+	PAYMENTREQUIRED            StatusCode = 402
+	FORBIDDEN                  StatusCode = 403
+	NOTFOUND                   StatusCode = 404
+	METHODNOTALLOWED           StatusCode = 405
+	NOTACCEPTABLE              StatusCode = 406
+	PROXYAUTHREQUIRED          StatusCode = 407
+	REQUESTTIMEOUT             StatusCode = 408
+	CONFLICT                   StatusCode = 409
+	GONE                       StatusCode = 410
+	LENGTHREQUIRED             StatusCode = 411
+	PRECONDITIONFAILED         StatusCode = 412
+	CONTENTTOOLARGE            StatusCode = 413
+	URITOOLONG                 StatusCode = 414
+	UNSUPPORTEDMEDIATYPE       StatusCode = 415
+	RANGENOTSATISFIABLE        StatusCode = 416
+	EXPECTATIONFAILED          StatusCode = 417
+	MISDIRECTEDREQUEST         StatusCode = 421
+	UNPROCESSABLECONTENT       StatusCode = 422
+	LOCKED                     StatusCode = 423
+	FAILEDDEPENDENCY           StatusCode = 424
+	TOOEARLY                   StatusCode = 425
+	UPGRADEREQUIRED            StatusCode = 426
+	PRECONDITIONREQUIRED       StatusCode = 428
+	TOOMANYREQUESTS            StatusCode = 429
+	HEADERFIELDSTOOLARGE       StatusCode = 431
+	UNAVAILABLEFORLEGALREASONS StatusCode = 451
+
+	SERVERERROR           StatusCode = 500
+	NOTIMPLEMENTED        StatusCode = 501
+	BADGATEWAY            StatusCode = 502
+	SERVICEUNAVAILABLE    StatusCode = 503
+	GATEWAYTIMEOUT        StatusCode = 504
+	VERSIONNOTSUPPORTED   StatusCode = 505
+	VARIANTALSONEGOTIATES StatusCode = 506
+	INSUFFICIENTSTORAGE   StatusCode = 507
+	LOOPDETECTED          StatusCode = 508
+	NOTEXTENDED           StatusCode = 510
+	NETWORKAUTHREQUIRED   StatusCode = 511
+)
+
+var statusText = map[StatusCode]string{
+	CONTINUE:           "Continue",
+	SWITCHINGPROTOCOLS: "Switching Protocols",
+	PROCESSING:         "Processing",
+	EARLYHINTS:         "Early Hints",
+
+	OK:                   "OK",
+	CREATED:              "Created",
+	ACCEPTED:             "Accepted",
+	NONAUTHORITATIVEINFO: "Non-Authoritative Information",
+	NOCONTENT:            "No Content",
+	RESETCONTENT:         "Reset Content",
+	PARTIALCONTENT:       "Partial Content",
+	MULTISTATUS:          "Multi-Status",
+	ALREADYREPORTED:      "Already Reported",
+	IMUSED:               "IM Used",
+
+	MULTIPLECHOICES:   "Multiple Choices",
+	MOVEDPERMANENTLY:  "Moved Permanently",
+	FOUND:             "Found",
+	SEEOTHER:          "See Other",
+	NOTMODIFIED:       "Not Modified",
+	USEPROXY:          "Use Proxy",
+	TEMPORARYREDIRECT: "Temporary Redirect",
+	PERMANENTREDIRECT: "Permanent Redirect",
+
+	BADREQUEST:                 "Bad Request",
+	UNAUTHORIZED:               "Unauthorized",
+	PAYMENTREQUIRED:            "Payment Required",
+	FORBIDDEN:                  "Forbidden",
+	NOTFOUND:                   "Not Found",
+	METHODNOTALLOWED:           "Method Not Allowed",
+	NOTACCEPTABLE:              "Not Acceptable",
+	PROXYAUTHREQUIRED:          "Proxy Authentication Required",
+	REQUESTTIMEOUT:             "Request Timeout",
+	CONFLICT:                   "Conflict",
+	GONE:                       "Gone",
+	LENGTHREQUIRED:             "Length Required",
+	PRECONDITIONFAILED:         "Precondition Failed",
+	CONTENTTOOLARGE:            "Content Too Large",
+	URITOOLONG:                 "URI Too Long",
+	UNSUPPORTEDMEDIATYPE:       "Unsupported Media Type",
+	RANGENOTSATISFIABLE:        "Range Not Satisfiable",
+	EXPECTATIONFAILED:          "Expectation Failed",
+	MISDIRECTEDREQUEST:         "Misdirected Request",
+	UNPROCESSABLECONTENT:       "Unprocessable Content",
+	LOCKED:                     "Locked",
+	FAILEDDEPENDENCY:           "Failed Dependency",
+	TOOEARLY:                   "Too Early",
+	UPGRADEREQUIRED:            "Upgrade Required",
+	PRECONDITIONREQUIRED:       "Precondition Required",
+	TOOMANYREQUESTS:            "Too Many Requests",
+	HEADERFIELDSTOOLARGE:       "Request Header Fields Too Large",
+	UNAVAILABLEFORLEGALREASONS: "Unavailable For Legal Reasons",
+
+	SERVERERROR:           "Internal Server Error",
+	NOTIMPLEMENTED:        "Not Implemented",
+	BADGATEWAY:            "Bad Gateway",
+	SERVICEUNAVAILABLE:    "Service Unavailable",
+	GATEWAYTIMEOUT:        "Gateway Timeout",
+	VERSIONNOTSUPPORTED:   "HTTP Version Not Supported",
+	VARIANTALSONEGOTIATES: "Variant Also Negotiates",
+	INSUFFICIENTSTORAGE:   "Insufficient Storage",
+	LOOPDETECTED:          "Loop Detected",
+	NOTEXTENDED:           "Not Extended",
+	NETWORKAUTHREQUIRED:   "Network Authentication Required",
+}
+
+// StatusText returns the standard reason phrase for a status code, or an
+// empty string if the code is not registered.
+func StatusText(statusCode StatusCode) string {
+	return statusText[statusCode]
+}
+
+func validateStatusLine(statusCode StatusCode, reason string) error {
+	if statusCode < 100 || statusCode > 999 {
+		return fmt.Errorf("invalid status code: %d", statusCode)
+	}
+
+	for i := 0; i < len(reason); i++ {
+		c := reason[i]
+		if c != '\t' && (c < ' ' || c == 0x7f) {
+			return fmt.Errorf("invalid reason phrase: %q", reason)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,395 @@
+package router
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/davidw1457/httpfromtcp/internal/headers"
+	"github.com/davidw1457/httpfromtcp/internal/request"
+	"github.com/davidw1457/httpfromtcp/internal/response"
+	"github.com/davidw1457/httpfromtcp/internal/server"
+)
+
+// Router dispatches requests to handlers registered by method and path
+// pattern. Its Serve method is a server.Handler.
+//
+// Patterns are an optional method followed by a path, e.g. "GET /users/{id}".
+// A "{name}" segment matches exactly one path segment and a final
+// "{name...}" segment matches the rest of the path. A pattern without a
+// method matches every method. Trailing slashes are significant; a request
+// that only matches once its trailing slash is added or removed is
+// redirected.
+type Router struct {
+	NotFound server.Handler
+
+	routes []*route
+}
+
+type route struct {
+	method   string
+	pattern  string
+	segments []segment
+	handler  server.Handler
+}
+
+type segment struct {
+	literal  string
+	param    string
+	wildcard bool
+}
+
+func New() *Router {
+	return &Router{}
+}
+
+// Handle registers handler for pattern. It panics if the pattern is invalid
+// or already registered.
+func (r *Router) Handle(pattern string, handler server.Handler) {
+	route, err := parsePattern(pattern)
+	if err != nil {
+		panic(fmt.Sprintf("router.Handle: %s", err))
+	}
+
+	for _, existing := range r.routes {
+		if existing.method == route.method &&
+			slices.Equal(existing.segments, route.segments) {
+			panic(fmt.Sprintf(
+				"router.Handle: pattern %q conflicts with %q",
+				pattern,
+				existing.pattern,
+			))
+		}
+	}
+
+	route.handler = handler
+	r.routes = append(r.routes, route)
+}
+
+func (r *Router) Serve(w *response.Writer, req *request.Request) {
+	method := req.RequestLine.Method
+	target := req.RequestLine.RequestTarget
+
+	if method == "OPTIONS" && target == "*" {
+		writeOptions(w, r.allMethods())
+		return
+	}
+
+	path, query := splitTarget(target)
+
+	matched, params, allowed := r.match(method, path)
+	if matched != nil {
+		req.PathParams = params
+		matched.handler(w, req)
+		return
+	}
+
+	if len(allowed) > 0 {
+		if method == "OPTIONS" {
+			writeOptions(w, allowed)
+			return
+		}
+
+		h := response.GetDefaultHeaders(0)
+		h.Set("Allow", strings.Join(allowed, ", "))
+		writeStatus(w, response.METHODNOTALLOWED, h)
+		return
+	}
+
+	redirect, ok := r.redirectPath(method, path)
+	if ok {
+		statusCode := response.PERMANENTREDIRECT
+		if method == "GET" || method == "HEAD" {
+			statusCode = response.MOVEDPERMANENTLY
+		}
+		if query != "" {
+			redirect = redirect + "?" + query
+		}
+
+		h := response.GetDefaultHeaders(0)
+		h.Set("Location", redirect)
+		writeStatus(w, statusCode, h)
+		return
+	}
+
+	if r.NotFound != nil {
+		r.NotFound(w, req)
+		return
+	}
+	writeStatus(w, response.NOTFOUND, response.GetDefaultHeaders(0))
+}
+
+// match returns the most specific route for method and path. When the path
+// matches but the method does not, it returns the methods that would have
+// been accepted instead.
+func (r *Router) match(
+	method string,
+	path string,
+) (*route, map[string]string, []string) {
+	pathSegments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+
+	var best *route
+	var bestParams map[string]string
+	var allowed []string
+	for _, route := range r.routes {
+		params, ok := route.matchPath(pathSegments)
+		if !ok {
+			continue
+		}
+
+		if !route.allows(method) {
+			allowed = append(allowed, route.methods()...)
+			continue
+		}
+
+		if best == nil || route.moreSpecificThan(best) {
+			best = route
+			bestParams = params
+		}
+	}
+
+	if best != nil {
+		return best, bestParams, nil
+	}
+
+	if len(allowed) > 0 {
+		allowed = append(allowed, "OPTIONS")
+		slices.Sort(allowed)
+		allowed = slices.Compact(allowed)
+	}
+
+	return nil, nil, allowed
+}
+
+func (r *Router) redirectPath(method string, path string) (string, bool) {
+	var alternate string
+	if strings.HasSuffix(path, "/") {
+		alternate = strings.TrimSuffix(path, "/")
+	} else {
+		alternate = path + "/"
+	}
+	if alternate == "" {
+		return "", false
+	}
+
+	matched, _, allowed := r.match(method, alternate)
+	if matched == nil && len(allowed) == 0 {
+		return "", false
+	}
+
+	return alternate, true
+}
+
+func (r *Router) allMethods() []string {
+	methods := []string{"OPTIONS"}
+	for _, route := range r.routes {
+		methods = append(methods, route.methods()...)
+	}
+	slices.Sort(methods)
+	return slices.Compact(methods)
+}
+
+func parsePattern(pattern string) (*route, error) {
+	method, path, found := strings.Cut(pattern, " ")
+	if !found {
+		method, path = "", pattern
+	}
+	path = strings.TrimSpace(path)
+
+	if method != "" && strings.ToUpper(method) != method {
+		return nil, fmt.Errorf("invalid method in pattern %q", pattern)
+	}
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("pattern %q must begin with /", pattern)
+	}
+
+	rawSegments := strings.Split(path[1:], "/")
+	segments := make([]segment, 0, len(rawSegments))
+	seen := map[string]bool{}
+	for i, raw := range rawSegments {
+		if raw == "*" {
+			raw = "{...}"
+		}
+		if !strings.HasPrefix(raw, "{") || !strings.HasSuffix(raw, "}") {
+			if strings.ContainsAny(raw, "{}") {
+				return nil, fmt.Errorf("invalid segment %q in %q", raw, pattern)
+			}
+			segments = append(segments, segment{literal: raw})
+			continue
+		}
+
+		name := raw[1 : len(raw)-1]
+		wildcard := strings.HasSuffix(name, "...")
+		name = strings.TrimSuffix(name, "...")
+		if wildcard && i != len(rawSegments)-1 {
+			return nil, fmt.Errorf("wildcard must be last in %q", pattern)
+		}
+		if !wildcard && name == "" {
+			return nil, fmt.Errorf("unnamed parameter in %q", pattern)
+		}
+		if name != "" && seen[name] {
+			return nil, fmt.Errorf("duplicate parameter %q in %q", name, pattern)
+		}
+		seen[name] = true
+
+		segments = append(segments, segment{param: name, wildcard: wildcard})
+	}
+
+	return &route{
+		method:   method,
+		pattern:  pattern,
+		segments: segments,
+	}, nil
+}
+
+func (rt *route) matchPath(pathSegments []string) (map[string]string, bool) {
+	params := map[string]string{}
+	for i, seg := range rt.segments {
+		if seg.wildcard {
+			if i >= len(pathSegments) {
+				return nil, false
+			}
+			if seg.param != "" {
+				rest := strings.Join(pathSegments[i:], "/")
+				params[seg.param] = unescape(rest)
+			}
+			return params, true
+		}
+
+		if i >= len(pathSegments) {
+			return nil, false
+		}
+
+		if seg.param != "" {
+			if pathSegments[i] == "" {
+				return nil, false
+			}
+			params[seg.param] = unescape(pathSegments[i])
+			continue
+		}
+
+		if seg.literal != pathSegments[i] {
+			return nil, false
+		}
+	}
+
+	if len(pathSegments) != len(rt.segments) {
+		return nil, false
+	}
+
+	return params, true
+}
+
+func (rt *route) allows(method string) bool {
+	return rt.method == "" ||
+		rt.method == method ||
+		(rt.method == "GET" && method == "HEAD")
+}
+
+func (rt *route) methods() []string {
+	switch rt.method {
+	case "":
+		return []string{"DELETE", "GET", "HEAD", "PATCH", "POST", "PUT"}
+	case "GET":
+		return []string{"GET", "HEAD"}
+	default:
+		return []string{rt.method}
+	}
+}
+
+// moreSpecificThan prefers literal segments over parameters and parameters
+// over wildcards, comparing from the left. Routes bound to a method win
+// over routes that accept any method.
+func (rt *route) moreSpecificThan(other *route) bool {
+	for i := 0; i < len(rt.segments) && i < len(other.segments); i++ {
+		a, b := rt.segments[i].rank(), other.segments[i].rank()
+		if a != b {
+			return a > b
+		}
+	}
+
+	if len(rt.segments) != len(other.segments) {
+		return len(rt.segments) > len(other.segments)
+	}
+
+	return rt.method != "" && other.method == ""
+}
+
+func (seg segment) rank() int {
+	switch {
+	case seg.wildcard:
+		return 0
+	case seg.param != "":
+		return 1
+	default:
+		return 2
+	}
+}
+
+func splitTarget(target string) (string, string) {
+	if i := strings.Index(target, "://"); i != -1 {
+		target = target[i+3:]
+		slash := strings.Index(target, "/")
+		if slash == -1 {
+			target = "/"
+		} else {
+			target = target[slash:]
+		}
+	}
+
+	path, query, _ := strings.Cut(target, "?")
+	path, _, _ = strings.Cut(path, "#")
+	return path, query
+}
+
+func unescape(s string) string {
+	unescaped, err := url.PathUnescape(s)
+	if err != nil {
+		return s
+	}
+	return unescaped
+}
+
+func writeOptions(w *response.Writer, methods []string) {
+	h := response.GetDefaultHeaders(0)
+	h.Delete("Content-Length")
+	h.Delete("Content-Type")
+	h.Set("Allow", strings.Join(methods, ", "))
+	writeStatus(w, response.NOCONTENT, h)
+}
+
+func writeStatus(
+	w *response.Writer,
+	statusCode response.StatusCode,
+	h headers.Headers,
+) {
+	var body []byte
+	if statusCode != response.NOCONTENT {
+		body = []byte(fmt.Sprintf(
+			"%d %s\n",
+			statusCode,
+			response.StatusText(statusCode),
+		))
+		h.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+
+	err := w.WriteStatusLine(statusCode)
+	if err != nil {
+		log.Printf("router.writeStatus: %s\n", err)
+		return
+	}
+
+	err = w.WriteHeaders(h)
+	if err != nil {
+		log.Printf("router.writeStatus: %s\n", err)
+		return
+	}
+
+	_, err = w.WriteBody(body)
+	if err != nil {
+		log.Printf("router.writeStatus: %s\n", err)
+	}
+}
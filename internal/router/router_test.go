@@ -0,0 +1,136 @@
+package router
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/davidw1457/httpfromtcp/internal/request"
+	"github.com/davidw1457/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouterMatch(t *testing.T) {
+	r := New()
+	r.Handle("GET /users", named("list"))
+	r.Handle("POST /users", named("create"))
+	r.Handle("GET /users/{id}", named("get"))
+	r.Handle("GET /users/me", named("me"))
+	r.Handle("DELETE /users/{id}", named("delete"))
+	r.Handle("/files/{path...}", named("files"))
+	r.Handle("GET /docs/", named("docs"))
+
+	// Test: Literal route
+	out := serve(t, r, "GET", "/users")
+	assert.Contains(t, out, "HTTP/1.1 200 OK\r\n")
+	assert.True(t, strings.HasSuffix(out, "list"))
+
+	// Test: Method selects between routes on the same path
+	out = serve(t, r, "POST", "/users")
+	assert.True(t, strings.HasSuffix(out, "create"))
+
+	// Test: Path parameter
+	out = serve(t, r, "GET", "/users/42?verbose=1")
+	assert.True(t, strings.HasSuffix(out, "get id=42"))
+
+	// Test: Literal segment beats parameter
+	out = serve(t, r, "GET", "/users/me")
+	assert.True(t, strings.HasSuffix(out, "me"))
+
+	// Test: Percent-encoded parameter is decoded
+	out = serve(t, r, "DELETE", "/users/a%20b")
+	assert.True(t, strings.HasSuffix(out, "delete id=a b"))
+
+	// Test: Wildcard captures the rest of the path for any method
+	out = serve(t, r, "PUT", "/files/a/b/c.txt")
+	assert.True(t, strings.HasSuffix(out, "files path=a/b/c.txt"))
+
+	// Test: HEAD falls back to GET
+	out = serve(t, r, "HEAD", "/users")
+	assert.True(t, strings.HasSuffix(out, "list"))
+}
+
+func TestRouterErrors(t *testing.T) {
+	r := New()
+	r.Handle("GET /users", named("list"))
+	r.Handle("POST /users", named("create"))
+	r.Handle("GET /docs/", named("docs"))
+	r.Handle("PUT /files/{path...}", named("files"))
+
+	// Test: Unknown path
+	out := serve(t, r, "GET", "/missing")
+	assert.Contains(t, out, "HTTP/1.1 404 Not Found\r\n")
+
+	// Test: Known path, wrong method
+	out = serve(t, r, "DELETE", "/users")
+	assert.Contains(t, out, "HTTP/1.1 405 Method Not Allowed\r\n")
+	assert.Contains(t, out, "Allow: GET, HEAD, OPTIONS, POST\r\n")
+
+	// Test: OPTIONS lists allowed methods
+	out = serve(t, r, "OPTIONS", "/users")
+	assert.Contains(t, out, "HTTP/1.1 204 No Content\r\n")
+	assert.Contains(t, out, "Allow: GET, HEAD, OPTIONS, POST\r\n")
+
+	// Test: OPTIONS * lists every registered method
+	out = serve(t, r, "OPTIONS", "*")
+	assert.Contains(t, out, "Allow: GET, HEAD, OPTIONS, POST, PUT\r\n")
+
+	// Test: Missing trailing slash redirects
+	out = serve(t, r, "GET", "/docs?page=2")
+	assert.Contains(t, out, "HTTP/1.1 301 Moved Permanently\r\n")
+	assert.Contains(t, out, "Location: /docs/?page=2\r\n")
+
+	// Test: Extra trailing slash redirects
+	out = serve(t, r, "POST", "/users/")
+	assert.Contains(t, out, "HTTP/1.1 308 Permanent Redirect\r\n")
+	assert.Contains(t, out, "Location: /users\r\n")
+
+	// Test: Wildcard root without slash redirects
+	out = serve(t, r, "PUT", "/files")
+	assert.Contains(t, out, "Location: /files/\r\n")
+
+	// Test: Custom not found handler
+	r.NotFound = named("custom")
+	out = serve(t, r, "GET", "/missing")
+	assert.True(t, strings.HasSuffix(out, "custom"))
+}
+
+func TestRouterInvalidPatterns(t *testing.T) {
+	r := New()
+	r.Handle("GET /users/{id}", named("get"))
+
+	assert.Panics(t, func() { r.Handle("GET /users/{id}", named("again")) })
+	assert.Panics(t, func() { r.Handle("users", named("relative")) })
+	assert.Panics(t, func() { r.Handle("GET /{rest...}/x", named("mid")) })
+	assert.Panics(t, func() { r.Handle("GET /{a}/{a}", named("dup")) })
+	assert.Panics(t, func() { r.Handle("get /lower", named("lower")) })
+	assert.NotPanics(t, func() { r.Handle("POST /users/{id}", named("post")) })
+}
+
+func named(name string) func(*response.Writer, *request.Request) {
+	return func(w *response.Writer, req *request.Request) {
+		body := name
+		for _, param := range []string{"id", "path"} {
+			if v, ok := req.PathParams[param]; ok {
+				body = fmt.Sprintf("%s %s=%s", body, param, v)
+			}
+		}
+
+		_ = w.WriteStatusLine(response.OK)
+		_ = w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		_, _ = w.WriteBody([]byte(body))
+	}
+}
+
+func serve(t *testing.T, r *Router, method string, target string) string {
+	req, err := request.RequestFromReader(strings.NewReader(
+		fmt.Sprintf("%s %s HTTP/1.1\r\nHost: localhost\r\n\r\n", method, target),
+	))
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	r.Serve(response.NewWriter(buf), req)
+	return buf.String()
+}
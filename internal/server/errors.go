@@ -0,0 +1,108 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/davidw1457/httpfromtcp/internal/headers"
+	"github.com/davidw1457/httpfromtcp/internal/request"
+	"github.com/davidw1457/httpfromtcp/internal/response"
+)
+
+// errHeaderTimeout marks a connection whose first request line or header
+// section had not fully arrived when the header timeout passed. Only these
+// get a 408; an idle keep-alive connection, or one that times out while
+// sending a body, is closed.
+var errHeaderTimeout = errors.New("timed out reading request header fields")
+
+type ErrorHandler func(
+	w *response.Writer,
+	statusCode response.StatusCode,
+	err error,
+)
+
+func errorStatusCode(err error) (response.StatusCode, bool) {
+	switch {
+	case errors.Is(err, errHeaderTimeout):
+		return response.REQUESTTIMEOUT, true
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return 0, false
+	case errors.Is(err, request.ErrRequestLineTooLong):
+		return response.URITOOLONG, true
+	case errors.Is(err, request.ErrHeadersTooLarge):
+		return response.HEADERFIELDSTOOLARGE, true
+	case errors.Is(err, request.ErrBodyTooLarge):
+		return response.CONTENTTOOLARGE, true
+	case errors.Is(err, request.ErrUnsupportedVersion):
+		return response.VERSIONNOTSUPPORTED, true
+	case errors.Is(err, request.ErrUnsupportedTransferEncoding):
+		return response.NOTIMPLEMENTED, true
+	case errors.Is(err, request.ErrMalformedRequestLine),
+		errors.Is(err, request.ErrInvalidContentLength),
+		errors.Is(err, request.ErrMalformedChunk),
+		errors.Is(err, headers.ErrMalformedHeader):
+		return response.BADREQUEST, true
+	default:
+		return 0, false
+	}
+}
+
+func (s *Server) writeError(
+	w *response.Writer,
+	statusCode response.StatusCode,
+	err error,
+) {
+	if s.ErrorHandler != nil {
+		s.ErrorHandler(w, statusCode, err)
+		return
+	}
+
+	err = writeErrorPage(w, statusCode)
+	if err != nil {
+		s.logger().Printf("server.writeError: %s\n", err)
+	}
+}
+
+// DefaultErrorHandler answers with a short plain-text page naming the
+// status and closes the connection. The server uses it, logging through
+// its own Logger, when ErrorHandler is nil.
+func DefaultErrorHandler(
+	w *response.Writer,
+	statusCode response.StatusCode,
+	_ error,
+) {
+	err := writeErrorPage(w, statusCode)
+	if err != nil {
+		log.Printf("server.DefaultErrorHandler: %s\n", err)
+	}
+}
+
+func writeErrorPage(w *response.Writer, statusCode response.StatusCode) error {
+	body := []byte(fmt.Sprintf(
+		"%d %s\n",
+		statusCode,
+		response.StatusText(statusCode),
+	))
+
+	err := w.WriteStatusLine(statusCode)
+	if err != nil {
+		return fmt.Errorf("writeErrorPage: %w", err)
+	}
+
+	headers := response.GetDefaultHeaders(len(body))
+	headers.Set("Connection", "close")
+
+	err = w.WriteHeaders(headers)
+	if err != nil {
+		return fmt.Errorf("writeErrorPage: %w", err)
+	}
+
+	_, err = w.WriteBody(body)
+	if err != nil {
+		return fmt.Errorf("writeErrorPage: %w", err)
+	}
+
+	return nil
+}
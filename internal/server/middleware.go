@@ -0,0 +1,13 @@
+package server
+
+type Middleware func(Handler) Handler
+
+// Chain wraps handler in middlewares. The first middleware is the outermost
+// one, so it sees the request first and the finished response last.
+func Chain(handler Handler, middlewares ...Middleware) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+
+	return handler
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	"github.com/davidw1457/httpfromtcp/internal/request"
+	"github.com/davidw1457/httpfromtcp/internal/response"
+)
+
+type PanicHandler func(req *request.Request, v any, stack []byte)
+
+// serveRequest runs the handler and reports whether it panicked. A panic
+// is answered with a 500 if the handler had not started its response yet;
+// either way the caller must close the connection.
+func (s *Server) serveRequest(
+	w *response.Writer,
+	req *request.Request,
+) (panicked bool) {
+	defer func() {
+		v := recover()
+		if v == nil {
+			return
+		}
+		panicked = true
+
+		stack := debug.Stack()
+		s.logger().Printf(
+			"server.serveRequest: panic serving %s %s: %v\n%s",
+			req.RequestLine.Method,
+			req.RequestLine.RequestTarget,
+			v,
+			stack,
+		)
+
+		if s.PanicHandler != nil {
+			s.PanicHandler(req, v, stack)
+		}
+
+		if w.StatusCode() == 0 {
+			s.writeError(w, response.SERVERERROR, fmt.Errorf("panic: %v", v))
+		}
+	}()
+
+	s.Handler(w, req)
+
+	return false
+}
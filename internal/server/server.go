@@ -1,60 +1,232 @@
 package server
 
 import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"os"
+	"sync"
 	"sync/atomic"
+	"time"
 
+	"github.com/davidw1457/httpfromtcp/internal/headers"
 	"github.com/davidw1457/httpfromtcp/internal/request"
 	"github.com/davidw1457/httpfromtcp/internal/response"
 )
 
+// Server holds both the configuration and the runtime state of an HTTP
+// server. Set the exported fields, then call Start.
+//
+// The server listens on exactly one of Listener, UnixSocket or Addr, checked
+// in that order. Addr is a TCP host:port such as "127.0.0.1:8080",
+// "[::1]:8080" or ":0"; it defaults to ":80".
 type Server struct {
+	Addr       string
+	Listener   net.Listener
+	UnixSocket string
+	// TLSConfig is used by StartTLS. ClientCAs and ClientAuth override the
+	// matching TLSConfig fields to require client certificates; setting
+	// ClientCAs alone requires and verifies one. AuthorizeClient, if set,
+	// rejects the handshake when it returns an error for the verified client
+	// certificate, or when there is none. See AllowClientNames.
+	TLSConfig       *tls.Config
+	ClientCAs       *x509.CertPool
+	ClientAuth      tls.ClientAuthType
+	AuthorizeClient func(cert *x509.Certificate) error
+
+	Handler    Handler
+	StreamBody bool
+	// Limits defaults to request.DefaultLimits when left zero.
+	Limits request.Limits
+	// ObsFold defaults to rejecting requests that use obsolete line
+	// folding with 400 Bad Request.
+	ObsFold headers.ObsFoldPolicy
+	// ErrorHandler writes the response sent when a request cannot be
+	// parsed. The connection is closed afterwards.
+	ErrorHandler ErrorHandler
+	// PanicHandler, if set, is called with the recovered value and stack
+	// trace whenever Handler panics.
+	PanicHandler PanicHandler
+
+	// ReadHeaderTimeout bounds the time from the start of a request until
+	// its headers are parsed, and from accepting a connection until its
+	// first request arrives; it falls back to ReadTimeout. Passing it gets
+	// a 408. ReadTimeout
+	// bounds reading the whole request, body included. WriteTimeout bounds
+	// writing the response, starting once the headers are read. IdleTimeout
+	// bounds waiting for the next request on a keep-alive connection; it
+	// falls back to ReadTimeout. Zero means no timeout.
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+
+	// Logger defaults to the standard logger.
+	Logger *log.Logger
+
 	closed   atomic.Bool
 	listener net.Listener
-	handler  Handler
+	mu       sync.Mutex
+	conns    map[net.Conn]connState
 }
 
 type Handler func(w *response.Writer, req *request.Request)
 
+const (
+	lingerTimeout  = 500 * time.Millisecond
+	maxLingerBytes = 256 * 1024
+)
+
 func Serve(port int, handler Handler) (*Server, error) {
-	portString := fmt.Sprintf(":%d", port)
-	listener, err := net.Listen("tcp", portString)
+	server := &Server{
+		Handler: handler,
+	}
+
+	err := server.Listen(port)
 	if err != nil {
 		return nil, fmt.Errorf("serve: %w", err)
 	}
 
-	server := &Server{
-		closed:   atomic.Bool{},
-		listener: listener,
-		handler:  handler,
+	return server, nil
+}
+
+func (s *Server) Listen(port int) error {
+	s.Addr = fmt.Sprintf(":%d", port)
+
+	err := s.Start()
+	if err != nil {
+		return fmt.Errorf("server.Listen: %w", err)
 	}
-	go server.listen()
 
-	return server, nil
+	return nil
+}
+
+// Start binds the configured address and accepts connections in the
+// background.
+func (s *Server) Start() error {
+	listener, err := s.newListener()
+	if err != nil {
+		return fmt.Errorf("server.Start: %w", err)
+	}
+
+	s.listener = listener
+	go s.listen()
+
+	return nil
+}
+
+// ListenAddr returns the address the server is bound to, which differs from
+// Addr when it asked for port 0. It returns nil before Start.
+func (s *Server) ListenAddr() net.Addr {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+func (s *Server) newListener() (net.Listener, error) {
+	if (s.Listener != nil && (s.UnixSocket != "" || s.Addr != "")) ||
+		(s.UnixSocket != "" && s.Addr != "") {
+		return nil, fmt.Errorf(
+			"newListener: only one of Listener, UnixSocket and Addr may be set",
+		)
+	}
+
+	switch {
+	case s.Listener != nil:
+		return s.Listener, nil
+	case s.UnixSocket != "":
+		return listenUnix(s.UnixSocket)
+	default:
+		addr := s.Addr
+		if addr == "" {
+			addr = ":80"
+		}
+		return net.Listen("tcp", addr)
+	}
+}
+
+// listenUnix removes a stale socket left behind by a previous process
+// before binding path. Other kinds of file are left alone.
+func listenUnix(path string) (net.Listener, error) {
+	info, err := os.Lstat(path)
+	if err == nil && info.Mode()&os.ModeSocket != 0 {
+		err = os.Remove(path)
+		if err != nil {
+			return nil, fmt.Errorf("listenUnix: %w", err)
+		}
+	}
+
+	return net.Listen("unix", path)
 }
 
+func (s *Server) logger() *log.Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	return log.Default()
+}
+
+// Close stops accepting connections and immediately closes every open
+// connection. Use Shutdown to let in-flight requests finish.
 func (s *Server) Close() error {
-	if s.closed.Load() {
-		return fmt.Errorf("server.close: server already closed")
+	err := s.stopListening()
+	if err != nil {
+		return fmt.Errorf("server.Close: %w", err)
 	}
 
+	s.closeAllConns()
+
+	return nil
+}
+
+func (s *Server) stopListening() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed.Load() {
+		return fmt.Errorf("server already closed")
+	}
 	s.closed.Store(true)
 
+	if s.listener == nil {
+		return nil
+	}
+
 	err := s.listener.Close()
 	if err != nil {
-		return fmt.Errorf("server.Close: %w", err)
+		return fmt.Errorf("server.stopListening: %w", err)
+	}
+
+	if s.UnixSocket != "" {
+		err = os.Remove(s.UnixSocket)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("server.stopListening: %w", err)
+		}
 	}
 
 	return nil
 }
 
 func (s *Server) listen() {
-	for !s.closed.Load() {
+	for {
 		conn, err := s.listener.Accept()
 		if err != nil {
-			log.Printf("server.listen: %s\n", err)
+			if s.closed.Load() {
+				return
+			}
+			s.logger().Printf("server.listen: %s\n", err)
+			continue
+		}
+
+		if !s.trackConn(conn, connStateNew) {
+			s.untrackConn(conn)
+			s.closeConn(conn)
 			continue
 		}
 
@@ -63,13 +235,150 @@ func (s *Server) listen() {
 }
 
 func (s *Server) handle(conn net.Conn) {
-	defer conn.Close()
-	w := response.NewWriter(conn)
-	request, err := request.RequestFromReader(conn)
-	if err != nil {
-		log.Printf("server.handle: %s\n", err)
+	defer s.untrackConn(conn)
+	defer s.lingerClose(conn)
+	reader := request.NewReader(conn)
+	reader.StreamBody = true
+	reader.ObsFold = s.ObsFold
+	if s.Limits != (request.Limits{}) {
+		reader.Limits = s.Limits
+	}
+
+	for first := true; ; first = false {
+		start := time.Now()
+		if reader.Buffered() == 0 {
+			timeout := s.idleTimeout()
+			if first {
+				timeout = s.headerTimeout()
+			}
+			s.setReadDeadline(conn, start, timeout)
+			err := reader.Fill()
+			if first && errors.Is(err, os.ErrDeadlineExceeded) {
+				s.rejectRequest(conn, fmt.Errorf(
+					"server.handle: %w: %w",
+					errHeaderTimeout,
+					err,
+				))
+				return
+			}
+			if err != nil {
+				return
+			}
+			if !first {
+				start = time.Now()
+			}
+		}
+
+		// From the first byte of a request on, Shutdown waits for the
+		// connection instead of closing it.
+		s.trackConn(conn, connStateActive)
+
+		request, err := s.readRequest(conn, reader, start)
+		if err != nil {
+			s.rejectRequest(conn, err)
+			return
+		}
+
+		request.RemoteAddr = conn.RemoteAddr().String()
+		if tlsConn, ok := conn.(*tls.Conn); ok {
+			state := tlsConn.ConnectionState()
+			request.TLS = &state
+		}
+
+		w := response.NewWriter(conn)
+		if request.RequestLine.Method == "HEAD" {
+			w.OmitBody()
+		}
+		if s.serveRequest(w, request) {
+			return
+		}
+
+		err = request.BodyReader.Close()
+		if err != nil {
+			s.logger().Printf("server.handle: %s\n", err)
+
+			// A streamed body that broke a limit or its framing is
+			// answered the same way as one read before the handler ran,
+			// unless the handler already started a response.
+			statusCode, ok := errorStatusCode(err)
+			if ok && w.StatusCode() == 0 {
+				s.writeError(w, statusCode, err)
+			}
+			return
+		}
+
+		if request.Headers.ContainsToken("Connection", "close") ||
+			!w.KeepAlive() {
+			return
+		}
+
+		if !s.trackConn(conn, connStateIdle) {
+			return
+		}
+	}
+}
+
+// rejectRequest answers a request that could not be read with the matching
+// error status, if there is one. The caller closes the connection.
+func (s *Server) rejectRequest(conn net.Conn, err error) {
+	if errors.Is(err, io.EOF) || s.closed.Load() {
 		return
 	}
+	s.logger().Printf("server.handle: %s\n", err)
+
+	statusCode, ok := errorStatusCode(err)
+	if ok {
+		s.setWriteDeadline(conn, time.Now(), s.WriteTimeout)
+		s.writeError(response.NewWriter(conn), statusCode, err)
+	}
+}
+
+// readRequest reads the next request under the header timeout, counted
+// from start, then moves
+// the connection to the read and write timeouts for the rest of the
+// exchange. Unless StreamBody is set, the body is collected before the
+// handler runs.
+func (s *Server) readRequest(
+	conn net.Conn,
+	reader *request.Reader,
+	start time.Time,
+) (*request.Request, error) {
+	s.setReadDeadline(conn, start, s.headerTimeout())
+
+	req, err := reader.ReadRequest()
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return nil, fmt.Errorf("server.readRequest: %w: %w", errHeaderTimeout, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("server.readRequest: %w", err)
+	}
+
+	s.setReadDeadline(conn, start, s.ReadTimeout)
+	s.setWriteDeadline(conn, time.Now(), s.WriteTimeout)
+
+	if s.StreamBody {
+		return req, nil
+	}
+
+	body, err := io.ReadAll(req.BodyReader)
+	if err != nil {
+		return nil, fmt.Errorf("server.readRequest: %w", err)
+	}
+	req.Body = body
+	req.BodyReader = io.NopCloser(bytes.NewReader(body))
+
+	return req, nil
+}
+
+// lingerClose half-closes conn and discards whatever the client is still
+// sending before closing it. Closing a socket with unread input makes the
+// kernel send a reset, which can destroy the last response in flight.
+func (s *Server) lingerClose(conn net.Conn) {
+	halfCloser, ok := conn.(interface{ CloseWrite() error })
+	if ok && halfCloser.CloseWrite() == nil {
+		_ = conn.SetReadDeadline(time.Now().Add(lingerTimeout))
+		_, _ = io.Copy(io.Discard, io.LimitReader(conn, maxLingerBytes))
+	}
 
-	s.handler(w, request)
+	s.closeConn(conn)
 }
@@ -0,0 +1,368 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/davidw1457/httpfromtcp/internal/request"
+	"github.com/davidw1457/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeepAlive(t *testing.T) {
+	s := startServer(t, &Server{Handler: echoTarget})
+
+	// Test: Pipelined requests are answered in order on one connection
+	out := roundTrip(t, s,
+		"GET /one HTTP/1.1\r\n\r\n"+
+			"GET /two HTTP/1.1\r\n\r\n"+
+			"GET /three HTTP/1.1\r\nConnection: close\r\n\r\n",
+	)
+	assert.Equal(t, 3, strings.Count(out, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(out, "/three"))
+	assert.Less(t, strings.Index(out, "/one"), strings.Index(out, "/two"))
+
+	// Test: HEAD gets the headers of a GET without its body
+	out = roundTrip(t, s,
+		"HEAD /head HTTP/1.1\r\n\r\n"+
+			"GET /get HTTP/1.1\r\nConnection: close\r\n\r\n",
+	)
+	assert.Equal(t, 2, strings.Count(out, "Content-Length: "))
+	assert.NotContains(t, out, "/head")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n/get"))
+}
+
+func TestListenConfig(t *testing.T) {
+	// Test: Port 0 reports the port that was chosen
+	s := startServer(t, &Server{Addr: "127.0.0.1:0", Handler: echoTarget})
+	addr, ok := s.ListenAddr().(*net.TCPAddr)
+	require.True(t, ok)
+	assert.NotZero(t, addr.Port)
+	out := roundTrip(t, s, "GET /v4 HTTP/1.1\r\nConnection: close\r\n\r\n")
+	assert.True(t, strings.HasSuffix(out, "/v4"))
+
+	// Test: IPv6 loopback
+	if ln, err := net.Listen("tcp", "[::1]:0"); err == nil {
+		ln.Close()
+		s = startServer(t, &Server{Addr: "[::1]:0", Handler: echoTarget})
+		out = roundTrip(t, s, "GET /v6 HTTP/1.1\r\nConnection: close\r\n\r\n")
+		assert.True(t, strings.HasSuffix(out, "/v6"))
+	}
+
+	// Test: Existing listener
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s = startServer(t, &Server{Listener: ln, Handler: echoTarget})
+	assert.Equal(t, ln.Addr(), s.ListenAddr())
+	out = roundTrip(t, s, "GET /listener HTTP/1.1\r\nConnection: close\r\n\r\n")
+	assert.True(t, strings.HasSuffix(out, "/listener"))
+
+	// Test: Unix domain socket, replacing a stale socket file
+	path := filepath.Join(t.TempDir(), "server.sock")
+	stale, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	s = startServer(t, &Server{UnixSocket: path, Handler: echoTarget})
+	out = roundTrip(t, s, "GET /unix HTTP/1.1\r\nConnection: close\r\n\r\n")
+	assert.True(t, strings.HasSuffix(out, "/unix"))
+
+	// Test: Closing removes the socket file
+	require.NoError(t, s.Close())
+	_, err = os.Lstat(path)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// Test: Closing a server that was never started
+	assert.NoError(t, (&Server{Handler: echoTarget}).Close())
+
+	// Test: Conflicting listen options
+	err = (&Server{Addr: ":0", UnixSocket: path, Handler: echoTarget}).Start()
+	assert.Error(t, err)
+}
+
+func TestParseErrorResponse(t *testing.T) {
+	s := startServer(t, &Server{Handler: echoTarget})
+
+	// Test: Malformed request line
+	out := roundTrip(t, s, "GET /\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 400 Bad Request\r\n"))
+
+	// Test: Unsupported version
+	out = roundTrip(t, s, "GET / HTTP/1.0\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 505 HTTP Version Not Supported\r\n"))
+
+	// Test: Obsolete line folding
+	out = roundTrip(t, s, "GET / HTTP/1.1\r\nX-A: 1\r\n 2\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 400 Bad Request\r\n"))
+
+	// Test: Conflicting Content-Length values
+	out = roundTrip(t, s, "POST / HTTP/1.1\r\nContent-Length: 1\r\nContent-Length: 2\r\n\r\nab")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 400 Bad Request\r\n"))
+
+	// Test: Transfer-Encoding with Content-Length hides no second request
+	out = roundTrip(t, s, "POST / HTTP/1.1\r\n"+
+		"Transfer-Encoding: chunked\r\nContent-Length: 36\r\n\r\n"+
+		"0\r\n\r\nGET /smuggled HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 400 Bad Request\r\n"))
+	assert.NotContains(t, out, "/smuggled")
+
+	// Test: Custom error page
+	s = startServer(t, &Server{
+		Handler: echoTarget,
+		ErrorHandler: func(w *response.Writer, statusCode response.StatusCode, err error) {
+			_ = w.WriteStatusLine(statusCode)
+			_ = w.WriteHeaders(response.GetDefaultHeaders(6))
+			_, _ = w.WriteBody([]byte("custom"))
+		},
+	})
+	out = roundTrip(t, s, "GET /\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 400 Bad Request\r\n"))
+	assert.True(t, strings.HasSuffix(out, "custom"))
+}
+
+func TestStreamedBodyLimit(t *testing.T) {
+	s := startServer(t, &Server{
+		StreamBody: true,
+		Limits:     request.Limits{MaxBodySize: 10},
+		Handler: func(w *response.Writer, req *request.Request) {
+			_, err := io.ReadAll(req.BodyReader)
+			if err != nil {
+				return
+			}
+			echoTarget(w, req)
+		},
+	})
+
+	// Test: Chunked body growing past the limit while the handler reads it
+	out := roundTrip(t, s,
+		"POST /big HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n"+
+			"6\r\nhello \r\n6\r\nworld!\r\n0\r\n\r\n",
+	)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 413 Content Too Large\r\n"))
+
+	// Test: Body within the limit
+	out = roundTrip(t, s,
+		"POST /small HTTP/1.1\r\nContent-Length: 5\r\nConnection: close\r\n\r\nhello",
+	)
+	assert.True(t, strings.HasSuffix(out, "/small"))
+}
+
+func TestPanicRecovery(t *testing.T) {
+	reported := make(chan any, 1)
+	s := startServer(t, &Server{
+		Handler: func(w *response.Writer, req *request.Request) {
+			if req.RequestLine.RequestTarget == "/panic" {
+				panic("boom")
+			}
+			echoTarget(w, req)
+		},
+		PanicHandler: func(req *request.Request, v any, stack []byte) {
+			reported <- v
+		},
+	})
+
+	// Test: Panic becomes a 500 and the connection is closed
+	out := roundTrip(t, s,
+		"GET /panic HTTP/1.1\r\n\r\nGET /after HTTP/1.1\r\n\r\n",
+	)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 500 Internal Server Error\r\n"))
+	assert.NotContains(t, out, "/after")
+	assert.Equal(t, "boom", <-reported)
+
+	// Test: Server keeps serving after a panic
+	out = roundTrip(t, s, "GET /after HTTP/1.1\r\nConnection: close\r\n\r\n")
+	assert.True(t, strings.HasSuffix(out, "/after"))
+}
+
+func TestShutdown(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s := startServer(t, &Server{
+		Handler: func(w *response.Writer, req *request.Request) {
+			if req.RequestLine.RequestTarget == "/slow" {
+				close(started)
+				<-release
+			}
+			echoTarget(w, req)
+		},
+	})
+	addr := s.ListenAddr().String()
+
+	fresh, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer fresh.Close()
+
+	idle, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer idle.Close()
+	_, err = idle.Write([]byte("GET /fast HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	buf := make([]byte, 1024)
+	_, err = idle.Read(buf)
+	require.NoError(t, err)
+
+	active, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer active.Close()
+	_, err = active.Write([]byte("GET /slow HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	<-started
+
+	shutdownErr := make(chan error, 1)
+	go func() {
+		shutdownErr <- s.Shutdown(context.Background())
+	}()
+
+	// Test: Idle keep-alive connection is closed right away
+	require.NoError(t, idle.SetReadDeadline(time.Now().Add(2*time.Second)))
+	_, err = idle.Read(buf)
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: Connection that never sent a request is closed right away
+	require.NoError(t, fresh.SetReadDeadline(time.Now().Add(2*time.Second)))
+	_, err = fresh.Read(buf)
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: Shutdown waits for the in-flight request
+	select {
+	case err = <-shutdownErr:
+		t.Fatalf("Shutdown returned early: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Test: New connections are refused
+	_, err = net.Dial("tcp", addr)
+	assert.Error(t, err)
+
+	close(release)
+	require.NoError(t, active.SetReadDeadline(time.Now().Add(2*time.Second)))
+	out, err := io.ReadAll(active)
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(string(out), "/slow"))
+	require.NoError(t, <-shutdownErr)
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	s := startServer(t, &Server{
+		Handler: func(w *response.Writer, req *request.Request) {
+			<-release
+		},
+	})
+
+	conn, err := net.Dial("tcp", s.ListenAddr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+
+	// Test: Expired context forcibly closes remaining connections
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = s.Shutdown(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	_, err = conn.Read(make([]byte, 16))
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, os.ErrDeadlineExceeded)
+}
+
+func TestTimeouts(t *testing.T) {
+	s := startServer(t, &Server{
+		Handler:           echoTarget,
+		ReadHeaderTimeout: 100 * time.Millisecond,
+		ReadTimeout:       300 * time.Millisecond,
+		IdleTimeout:       100 * time.Millisecond,
+	})
+	addr := s.ListenAddr().String()
+
+	// Test: Headers trickling in past the header timeout get a 408
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost"))
+	require.NoError(t, err)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 408 Request Timeout\r\n"))
+
+	// Test: Silent connection gets a 408 once the header timeout passes
+	conn, err = net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	out, err = io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 408 Request Timeout\r\n"))
+
+	// Test: Body slower than the read timeout is cut off without a response
+	conn, err = net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("POST / HTTP/1.1\r\nContent-Length: 10\r\n\r\nabc"))
+	require.NoError(t, err)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	start := time.Now()
+	out, err = io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Empty(t, out)
+	assert.GreaterOrEqual(t, time.Since(start), 250*time.Millisecond)
+
+	// Test: Idle keep-alive connection is closed without a response
+	conn, err = net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET /first HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	out, err = io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(out), "HTTP/1.1"))
+	assert.True(t, strings.HasSuffix(string(out), "/first"))
+}
+
+func echoTarget(w *response.Writer, req *request.Request) {
+	body := []byte(req.RequestLine.RequestTarget)
+	_ = w.WriteStatusLine(response.OK)
+	_ = w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	_, _ = w.WriteBody(body)
+}
+
+func startServer(t *testing.T, s *Server) *Server {
+	t.Helper()
+	if s.Addr == "" && s.Listener == nil && s.UnixSocket == "" {
+		s.Addr = "127.0.0.1:0"
+	}
+	require.NoError(t, s.Start())
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+// roundTrip sends raw on a new connection and returns everything the server
+// writes until it closes the connection.
+func roundTrip(t *testing.T, s *Server, raw string) string {
+	t.Helper()
+	addr := s.ListenAddr()
+	conn, err := net.Dial(addr.Network(), addr.String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+	_, err = conn.Write([]byte(raw))
+	require.NoError(t, err)
+
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	return string(out)
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+const shutdownPollInterval = 10 * time.Millisecond
+
+type connState int
+
+const (
+	connStateNew connState = iota
+	connStateActive
+	connStateIdle
+)
+
+// Shutdown stops accepting connections, closes the ones that have not
+// started a request yet or are idle between requests, and waits for the
+// rest to finish their current request. If ctx expires first, the remaining
+// connections are closed and ctx's error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.stopListening()
+	if err != nil {
+		return fmt.Errorf("server.Shutdown: %w", err)
+	}
+
+	s.mu.Lock()
+	for conn, state := range s.conns {
+		if state != connStateActive {
+			s.closeConn(conn)
+		}
+	}
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if s.activeConns() == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			s.closeAllConns()
+			return fmt.Errorf("server.Shutdown: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// trackConn records the state of conn and reports whether the server is
+// still running. Connections becoming idle after shutdown has started should
+// be closed by the caller.
+func (s *Server) trackConn(conn net.Conn, state connState) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conns == nil {
+		s.conns = map[net.Conn]connState{}
+	}
+	s.conns[conn] = state
+
+	return !s.closed.Load()
+}
+
+func (s *Server) untrackConn(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.conns, conn)
+}
+
+func (s *Server) activeConns() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.conns)
+}
+
+func (s *Server) closeAllConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn := range s.conns {
+		s.closeConn(conn)
+	}
+}
+
+func (s *Server) closeConn(conn net.Conn) {
+	err := conn.Close()
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		s.logger().Printf("server.closeConn: %s\n", err)
+	}
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"net"
+	"time"
+)
+
+func (s *Server) headerTimeout() time.Duration {
+	if s.ReadHeaderTimeout > 0 {
+		return s.ReadHeaderTimeout
+	}
+	return s.ReadTimeout
+}
+
+func (s *Server) idleTimeout() time.Duration {
+	if s.IdleTimeout > 0 {
+		return s.IdleTimeout
+	}
+	return s.ReadTimeout
+}
+
+func (s *Server) setReadDeadline(conn net.Conn, start time.Time, timeout time.Duration) {
+	err := conn.SetReadDeadline(deadline(start, timeout))
+	if err != nil {
+		s.logger().Printf("server.setReadDeadline: %s\n", err)
+	}
+}
+
+func (s *Server) setWriteDeadline(conn net.Conn, start time.Time, timeout time.Duration) {
+	err := conn.SetWriteDeadline(deadline(start, timeout))
+	if err != nil {
+		s.logger().Printf("server.setWriteDeadline: %s\n", err)
+	}
+}
+
+// deadline returns the zero time, which clears a deadline, when timeout is
+// not set.
+func deadline(start time.Time, timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return start.Add(timeout)
+}
@@ -0,0 +1,259 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+const certCheckInterval = time.Second
+
+// CertFiles names a PEM encoded certificate chain and its private key.
+type CertFiles struct {
+	CertFile string
+	KeyFile  string
+}
+
+// CertReloader serves certificates loaded from disk and reloads them when
+// their files change. With several certificates, the one matching the
+// client's SNI name is chosen, falling back to the first.
+type CertReloader struct {
+	// Logger reports files that could not be reloaded. It defaults to the
+	// standard logger; StartTLS sets it to the server's Logger.
+	Logger *log.Logger
+
+	files         []CertFiles
+	checkInterval time.Duration
+
+	mu        sync.Mutex
+	certs     []*tls.Certificate
+	modTimes  []time.Time
+	lastCheck time.Time
+}
+
+func NewCertReloader(files ...CertFiles) (*CertReloader, error) {
+	if len(files) == 0 {
+		return nil, fmt.Errorf("NewCertReloader: no certificates")
+	}
+
+	cr := &CertReloader{
+		files:         files,
+		checkInterval: certCheckInterval,
+		certs:         make([]*tls.Certificate, len(files)),
+		modTimes:      make([]time.Time, len(files)),
+	}
+
+	for i := range files {
+		err := cr.load(i)
+		if err != nil {
+			return nil, fmt.Errorf("NewCertReloader: %w", err)
+		}
+	}
+	cr.lastCheck = time.Now()
+
+	return cr, nil
+}
+
+// GetCertificate is meant for tls.Config.GetCertificate.
+func (cr *CertReloader) GetCertificate(
+	hello *tls.ClientHelloInfo,
+) (*tls.Certificate, error) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	if time.Since(cr.lastCheck) >= cr.checkInterval {
+		cr.lastCheck = time.Now()
+		cr.reloadChanged()
+	}
+
+	if hello.ServerName != "" {
+		for _, cert := range cr.certs {
+			if hello.SupportsCertificate(cert) == nil {
+				return cert, nil
+			}
+		}
+	}
+
+	return cr.certs[0], nil
+}
+
+// reloadChanged keeps serving the previous certificate when a changed
+// file cannot be loaded, e.g. because it is only half written.
+func (cr *CertReloader) reloadChanged() {
+	for i := range cr.files {
+		modTime, err := cr.modTime(i)
+		if err != nil {
+			cr.logger().Printf("CertReloader.reloadChanged: %s\n", err)
+			continue
+		}
+		if modTime.Equal(cr.modTimes[i]) {
+			continue
+		}
+
+		err = cr.load(i)
+		if err != nil {
+			cr.logger().Printf("CertReloader.reloadChanged: %s\n", err)
+		}
+	}
+}
+
+func (cr *CertReloader) logger() *log.Logger {
+	if cr.Logger != nil {
+		return cr.Logger
+	}
+	return log.Default()
+}
+
+func (cr *CertReloader) load(i int) error {
+	modTime, err := cr.modTime(i)
+	if err != nil {
+		return fmt.Errorf("CertReloader.load: %w", err)
+	}
+
+	cert, err := tls.LoadX509KeyPair(cr.files[i].CertFile, cr.files[i].KeyFile)
+	if err != nil {
+		return fmt.Errorf("CertReloader.load: %w", err)
+	}
+
+	cr.certs[i] = &cert
+	cr.modTimes[i] = modTime
+
+	return nil
+}
+
+// modTime returns the later modification time of the certificate and key.
+func (cr *CertReloader) modTime(i int) (time.Time, error) {
+	certInfo, err := os.Stat(cr.files[i].CertFile)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("CertReloader.modTime: %w", err)
+	}
+
+	keyInfo, err := os.Stat(cr.files[i].KeyFile)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("CertReloader.modTime: %w", err)
+	}
+
+	if keyInfo.ModTime().After(certInfo.ModTime()) {
+		return keyInfo.ModTime(), nil
+	}
+	return certInfo.ModTime(), nil
+}
+
+// StartTLS is Start with TLS termination. When certFile and keyFile are
+// given they are served, and reloaded when they change, in place of any
+// certificates in TLSConfig. Otherwise TLSConfig must provide certificates,
+// for example through a CertReloader holding one pair per hostname.
+func (s *Server) StartTLS(certFile string, keyFile string) error {
+	config, err := s.tlsConfig(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("server.StartTLS: %w", err)
+	}
+
+	listener, err := s.newListener()
+	if err != nil {
+		return fmt.Errorf("server.StartTLS: %w", err)
+	}
+
+	s.listener = tls.NewListener(listener, config)
+	go s.listen()
+
+	return nil
+}
+
+func (s *Server) tlsConfig(certFile string, keyFile string) (*tls.Config, error) {
+	config := &tls.Config{}
+	if s.TLSConfig != nil {
+		config = s.TLSConfig.Clone()
+	}
+
+	if certFile != "" || keyFile != "" {
+		reloader, err := NewCertReloader(CertFiles{certFile, keyFile})
+		if err != nil {
+			return nil, fmt.Errorf("tlsConfig: %w", err)
+		}
+		reloader.Logger = s.Logger
+		config.Certificates = nil
+		config.GetCertificate = reloader.GetCertificate
+	}
+
+	if len(config.Certificates) == 0 &&
+		config.GetCertificate == nil &&
+		config.GetConfigForClient == nil {
+		return nil, fmt.Errorf("tlsConfig: no certificates configured")
+	}
+
+	if s.ClientCAs != nil {
+		config.ClientCAs = s.ClientCAs
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if s.ClientAuth != tls.NoClientCert {
+		config.ClientAuth = s.ClientAuth
+	}
+	if s.AuthorizeClient != nil {
+		config.VerifyConnection = s.verifyClient(config.VerifyConnection)
+	}
+
+	if config.MinVersion == 0 {
+		config.MinVersion = tls.VersionTLS12
+	}
+	if !slices.Contains(config.NextProtos, "http/1.1") {
+		config.NextProtos = append(slices.Clip(config.NextProtos), "http/1.1")
+	}
+
+	return config, nil
+}
+
+// verifyClient runs AuthorizeClient after any VerifyConnection callback
+// already present in TLSConfig. A client without a verified certificate
+// has no identity to authorize and is rejected.
+func (s *Server) verifyClient(
+	next func(tls.ConnectionState) error,
+) func(tls.ConnectionState) error {
+	return func(state tls.ConnectionState) error {
+		if next != nil {
+			err := next(state)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(state.VerifiedChains) == 0 {
+			return fmt.Errorf("server.verifyClient: no verified client certificate")
+		}
+
+		err := s.AuthorizeClient(state.VerifiedChains[0][0])
+		if err != nil {
+			return fmt.Errorf("server.verifyClient: %w", err)
+		}
+		return nil
+	}
+}
+
+// AllowClientNames authorizes client certificates whose subject common
+// name or any DNS, email or URI SAN equals one of names.
+func AllowClientNames(names ...string) func(cert *x509.Certificate) error {
+	return func(cert *x509.Certificate) error {
+		identities := []string{cert.Subject.CommonName}
+		identities = append(identities, cert.DNSNames...)
+		identities = append(identities, cert.EmailAddresses...)
+		for _, uri := range cert.URIs {
+			identities = append(identities, uri.String())
+		}
+
+		for _, identity := range identities {
+			if identity != "" && slices.Contains(names, identity) {
+				return nil
+			}
+		}
+
+		return fmt.Errorf(
+			"client certificate %q is not authorized",
+			cert.Subject.CommonName,
+		)
+	}
+}
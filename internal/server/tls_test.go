@@ -0,0 +1,304 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/davidw1457/httpfromtcp/internal/request"
+	"github.com/davidw1457/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStartTLS(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	certFile, keyFile := ca.issue(t, dir, "localhost", 1)
+
+	s := &Server{Addr: "127.0.0.1:0", Handler: echoTarget}
+	require.NoError(t, s.StartTLS(certFile, keyFile))
+	t.Cleanup(func() { _ = s.Close() })
+
+	// Test: Request over TLS with ALPN
+	conn := dialTLS(t, s, ca, "localhost", []string{"http/1.1"})
+	assert.Equal(t, "http/1.1", conn.ConnectionState().NegotiatedProtocol)
+	_, err := conn.Write([]byte("GET /secure HTTP/1.1\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(string(out), "/secure"))
+
+	// Test: Missing certificates
+	err = (&Server{Addr: "127.0.0.1:0", Handler: echoTarget}).StartTLS("", "")
+	assert.Error(t, err)
+}
+
+func TestCertReloader(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	aCert, aKey := ca.issue(t, dir, "a.test", 1)
+	bCert, bKey := ca.issue(t, dir, "b.test", 2)
+
+	reloader, err := NewCertReloader(
+		CertFiles{aCert, aKey},
+		CertFiles{bCert, bKey},
+	)
+	require.NoError(t, err)
+	reloader.checkInterval = 0
+	logs := make(logWriter, 16)
+	reloader.Logger = log.New(logs, "", 0)
+
+	s := &Server{
+		Addr:      "127.0.0.1:0",
+		Handler:   echoTarget,
+		TLSConfig: &tls.Config{GetCertificate: reloader.GetCertificate},
+	}
+	require.NoError(t, s.StartTLS("", ""))
+	t.Cleanup(func() { _ = s.Close() })
+
+	// Test: SNI selects the matching certificate
+	conn := dialTLS(t, s, ca, "a.test", nil)
+	assert.Equal(t, "a.test", peerName(conn))
+	conn = dialTLS(t, s, ca, "b.test", nil)
+	assert.Equal(t, "b.test", peerName(conn))
+
+	// Test: Changed files are picked up on the next handshake
+	ca.issue(t, dir, "a.test", 3)
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(aCert, future, future))
+	conn = dialTLS(t, s, ca, "a.test", nil)
+	assert.Equal(t, int64(3), conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64())
+
+	// Test: Broken files keep the previous certificate
+	require.NoError(t, os.WriteFile(bCert, []byte("garbage"), 0o600))
+	require.NoError(t, os.Chtimes(bCert, future, future))
+	conn = dialTLS(t, s, ca, "b.test", nil)
+	assert.Equal(t, int64(2), conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64())
+	assert.Contains(t, <-logs, "CertReloader.reloadChanged")
+}
+
+// logWriter hands each log line to the test over a channel, so lines
+// written while serving a handshake are safe to read.
+type logWriter chan string
+
+func (lw logWriter) Write(p []byte) (int, error) {
+	select {
+	case lw <- string(p):
+	default:
+	}
+	return len(p), nil
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := newTestCA(t)
+	otherCA := newTestCA(t)
+	dir := t.TempDir()
+	certFile, keyFile := ca.issue(t, dir, "localhost", 1)
+	allowed := ca.clientCert(t, dir, "billing.internal", 2)
+	denied := ca.clientCert(t, dir, "intruder.internal", 3)
+	foreign := otherCA.clientCert(t, t.TempDir(), "billing.internal", 4)
+
+	s := &Server{
+		Addr: "127.0.0.1:0",
+		Handler: func(w *response.Writer, req *request.Request) {
+			cert := req.ClientCertificate()
+			body := []byte(fmt.Sprintf(
+				"%s %s %s",
+				cert.Subject.CommonName,
+				tls.VersionName(req.TLS.Version),
+				req.TLS.ServerName,
+			))
+			_ = w.WriteStatusLine(response.OK)
+			_ = w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+			_, _ = w.WriteBody(body)
+		},
+		ClientCAs:       ca.pool,
+		AuthorizeClient: AllowClientNames("billing.internal"),
+	}
+	require.NoError(t, s.StartTLS(certFile, keyFile))
+	t.Cleanup(func() { _ = s.Close() })
+
+	// Test: Authorized client identity reaches the handler
+	out, err := mtlsRoundTrip(s, ca, &allowed)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(out, "billing.internal TLS 1.3 localhost"))
+
+	// Test: Verified but unauthorized client is rejected
+	out, err = mtlsRoundTrip(s, ca, &denied)
+	assert.Error(t, err)
+	assert.Empty(t, out)
+
+	// Test: Client certificate from an unknown CA is rejected
+	out, err = mtlsRoundTrip(s, ca, &foreign)
+	assert.Error(t, err)
+	assert.Empty(t, out)
+
+	// Test: Missing client certificate is rejected
+	out, err = mtlsRoundTrip(s, ca, nil)
+	assert.Error(t, err)
+	assert.Empty(t, out)
+
+	// Test: Optional client certificate is still needed to authorize
+	optional := &Server{
+		Addr:            "127.0.0.1:0",
+		Handler:         s.Handler,
+		ClientCAs:       ca.pool,
+		ClientAuth:      tls.VerifyClientCertIfGiven,
+		AuthorizeClient: AllowClientNames("billing.internal"),
+	}
+	require.NoError(t, optional.StartTLS(certFile, keyFile))
+	t.Cleanup(func() { _ = optional.Close() })
+	out, err = mtlsRoundTrip(optional, ca, nil)
+	assert.Error(t, err)
+	assert.Empty(t, out)
+	out, err = mtlsRoundTrip(optional, ca, &allowed)
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(out, "billing.internal TLS 1.3 localhost"))
+}
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue writes a certificate for name signed by the CA to dir and returns
+// the certificate and key paths. Issuing the same name again overwrites it.
+func (ca *testCA) issue(
+	t *testing.T,
+	dir string,
+	name string,
+	serial int64,
+	extKeyUsage ...x509.ExtKeyUsage,
+) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	if len(extKeyUsage) == 0 {
+		extKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  extKeyUsage,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	require.NoError(t, os.WriteFile(certFile, certPEM, 0o600))
+	require.NoError(t, os.WriteFile(keyFile, keyPEM, 0o600))
+
+	return certFile, keyFile
+}
+
+func (ca *testCA) clientCert(
+	t *testing.T,
+	dir string,
+	name string,
+	serial int64,
+) tls.Certificate {
+	t.Helper()
+	certFile, keyFile := ca.issue(t, dir, name, serial, x509.ExtKeyUsageClientAuth)
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	require.NoError(t, err)
+	return cert
+}
+
+func mtlsRoundTrip(s *Server, ca *testCA, cert *tls.Certificate) (string, error) {
+	config := &tls.Config{RootCAs: ca.pool, ServerName: "localhost"}
+	if cert != nil {
+		config.Certificates = []tls.Certificate{*cert}
+	}
+
+	conn, err := tls.Dial("tcp", s.ListenAddr().String(), config)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	err = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if err != nil {
+		return "", err
+	}
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nConnection: close\r\n\r\n"))
+	if err != nil {
+		return "", err
+	}
+
+	out, err := io.ReadAll(conn)
+	return string(out), err
+}
+
+func dialTLS(
+	t *testing.T,
+	s *Server,
+	ca *testCA,
+	serverName string,
+	nextProtos []string,
+) *tls.Conn {
+	t.Helper()
+	conn, err := tls.Dial("tcp", s.ListenAddr().String(), &tls.Config{
+		RootCAs:    ca.pool,
+		ServerName: serverName,
+		NextProtos: nextProtos,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+	return conn
+}
+
+func peerName(conn *tls.Conn) string {
+	return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+}